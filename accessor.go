@@ -1,8 +1,16 @@
 package prefer
 
 import (
+	"encoding/json"
 	"fmt"
-	"strings"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const keySeparator = "."
@@ -10,58 +18,153 @@ const keySeparator = "."
 // ConfigMap provides dot-notation access to configuration values.
 // It wraps a map[string]interface{} and supports nested key access
 // using dot-separated paths like "database.host".
+//
+// A ConfigMap is safe for concurrent use. Reads never lock, and each sees a
+// consistent version of the data, since changes copy the maps and lists they
+// modify rather than changing them in place. Maps and lists returned by Get,
+// GetMap, GetSlice and the other methods are copies, so that changing them
+// doesn't affect the ConfigMap or its snapshots.
 type ConfigMap struct {
-	data map[string]interface{}
+	state       atomic.Pointer[mapState]
+	frozen      atomic.Bool
+	updateMutex sync.Mutex
+
+	// parent and prefix are set for views returned by Sub, which hold no
+	// data of their own. parent is always the root map.
+	parent    *ConfigMap
+	prefix    []pathSegment
+	prefixErr error
+
+	watchMutex sync.Mutex
+	watchers   []*mapWatcher
+
+	// origins is the history of each value's origins, by key path, for maps
+	// built by a ConfigBuilder. It isn't modified after Build.
+	origins map[string][]Origin
 }
 
-// NewConfigMap creates a new ConfigMap from a map.
+// NewConfigMap creates a new ConfigMap from a copy of data, normalized so
+// that nested maps are map[string]interface{} and integers are int64,
+// regardless of how data was produced. data itself is left unchanged.
+//
+// Unlike Load and ConfigBuilder, which only keep numbers exact when asked
+// to with WithExactNumbers, numbers which can't be represented that way,
+// such as a *big.Int or json.Number, are always kept exact here: they can
+// only have come from the caller, who is unlikely to want them rounded.
 func NewConfigMap(data map[string]interface{}) *ConfigMap {
 	if data == nil {
-		data = make(map[string]interface{})
+		return newConfigMap(make(map[string]interface{}))
 	}
-	return &ConfigMap{data: data}
+	return newConfigMap(normalizeMap(copyTree(data).(map[string]interface{}), true))
+}
+
+func newConfigMap(data map[string]interface{}) *ConfigMap {
+	c := &ConfigMap{}
+	c.state.Store(&mapState{data: data})
+	return c
 }
 
 // LoadMap loads a configuration file into a ConfigMap for dot-notation access.
-func LoadMap(identifier string) (*ConfigMap, error) {
+// Options are passed to Load, such as WithExactNumbers to keep large numbers
+// exact.
+func LoadMap(identifier string, opts ...Option) (*ConfigMap, error) {
 	data := make(map[string]interface{})
-	_, err := Load(identifier, &data)
+	_, err := Load(identifier, &data, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return NewConfigMap(data), nil
 }
 
-// Get retrieves a value by dot-separated key path.
-// Returns the value and true if found, nil and false otherwise.
+// Get retrieves a value by dot-separated key path. List elements are
+// addressed by index, as in "servers.0.host" or "servers[0].host", with
+// negative indexes counting from the end. Keys containing dots or brackets
+// can be quoted, as in `hosts."example.com"`, or escaped with a backslash.
+// Returns the value and true if found, nil and false otherwise. Maps and
+// lists are returned as copies, which the caller may modify.
 func (c *ConfigMap) Get(key string) (interface{}, bool) {
-	if key == "" {
-		return c.data, true
-	}
-
-	parts := strings.Split(key, keySeparator)
-	var current interface{} = c.data
-
-	for _, part := range parts {
-		switch node := current.(type) {
-		case map[string]interface{}:
-			val, ok := node[part]
-			if !ok {
-				return nil, false
-			}
-			current = val
-		default:
-			return nil, false
-		}
+	value, ok := c.lookup(key)
+	return copyTree(value), ok
+}
+
+// lookup is like Get, but returns maps and lists which are shared with the
+// ConfigMap and must not be modified.
+func (c *ConfigMap) lookup(key string) (interface{}, bool) {
+	segments, err := c.resolve(key)
+	if err != nil {
+		return nil, false
+	}
+	return lookupPath(c.root().load(), segments)
+}
+
+// Get retrieves the value at key converted to T. Numbers are converted
+// between types only when no precision is lost, strings are parsed into
+// numbers, booleans and durations, and types implementing
+// encoding.TextUnmarshaler are unmarshaled from strings. Missing keys return
+// an error wrapping ErrKeyNotFound, and failed conversions a *FieldError.
+func Get[T any](c *ConfigMap, key string) (T, error) {
+	var result T
+	value, ok := c.lookup(key)
+	if !ok {
+		return result, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	if err := decodeValue(key, value, &result, true); err != nil {
+		var zero T
+		return zero, err
 	}
+	return result, nil
+}
+
+// GetOr retrieves the value at key converted to T as Get does, returning
+// fallback if the key is missing or can't be converted.
+func GetOr[T any](c *ConfigMap, key string, fallback T) T {
+	result, err := Get[T](c, key)
+	if err != nil {
+		return fallback
+	}
+	return result
+}
+
+// GetDuration retrieves a duration written like "30s" or "1h30m".
+func (c *ConfigMap) GetDuration(key string) (time.Duration, error) {
+	return Get[time.Duration](c, key)
+}
+
+// GetByteSize retrieves a size written like "512", "10MB" or "10MiB".
+func (c *ConfigMap) GetByteSize(key string) (ByteSize, error) {
+	return Get[ByteSize](c, key)
+}
+
+// GetURL retrieves a parsed URL.
+func (c *ConfigMap) GetURL(key string) (*url.URL, error) {
+	return Get[*url.URL](c, key)
+}
+
+// GetIP retrieves an IPv4 or IPv6 address.
+func (c *ConfigMap) GetIP(key string) (net.IP, error) {
+	return Get[net.IP](c, key)
+}
+
+// GetCIDR retrieves a network written in CIDR notation, like "10.0.0.0/8".
+func (c *ConfigMap) GetCIDR(key string) (*net.IPNet, error) {
+	return Get[*net.IPNet](c, key)
+}
 
-	return current, true
+// GetRegexp retrieves a compiled regular expression.
+func (c *ConfigMap) GetRegexp(key string) (*regexp.Regexp, error) {
+	return Get[*regexp.Regexp](c, key)
+}
+
+// GetTime retrieves a time written in RFC 3339 format, or as a date and
+// optional time without a zone, which is then taken to be UTC.
+func (c *ConfigMap) GetTime(key string) (time.Time, error) {
+	return Get[time.Time](c, key)
 }
 
 // GetString retrieves a string value by key.
 // Returns the value and true if found and is a string, empty string and false otherwise.
 func (c *ConfigMap) GetString(key string) (string, bool) {
-	val, ok := c.Get(key)
+	val, ok := c.lookup(key)
 	if !ok {
 		return "", false
 	}
@@ -70,27 +173,62 @@ func (c *ConfigMap) GetString(key string) (string, bool) {
 }
 
 // GetInt retrieves an integer value by key.
-// Handles both int and float64 (JSON numbers are float64).
+// Numbers of any type are converted, with floats truncated towards zero.
+// Returns false if the value isn't a number or doesn't fit in an int.
 func (c *ConfigMap) GetInt(key string) (int, bool) {
-	val, ok := c.Get(key)
+	val, ok := c.lookup(key)
 	if !ok {
 		return 0, false
 	}
 	switch v := val.(type) {
 	case int:
 		return v, true
-	case float64:
-		return int(v), true
 	case int64:
+		return intFromInt64(v)
+	case uint64:
+		if v > math.MaxInt {
+			return 0, false
+		}
 		return int(v), true
+	case float64:
+		return intFromFloat64(v)
+	case *big.Int:
+		if !v.IsInt64() {
+			return 0, false
+		}
+		return intFromInt64(v.Int64())
+	case json.Number:
+		if integer, err := v.Int64(); err == nil {
+			return intFromInt64(integer)
+		}
+		float, err := v.Float64()
+		if err != nil {
+			return 0, false
+		}
+		return intFromFloat64(float)
 	default:
 		return 0, false
 	}
 }
 
+func intFromInt64(value int64) (int, bool) {
+	if value < math.MinInt || value > math.MaxInt {
+		return 0, false
+	}
+	return int(value), true
+}
+
+func intFromFloat64(value float64) (int, bool) {
+	if math.IsNaN(value) || value < math.MinInt || value >= -float64(math.MinInt) {
+		return 0, false
+	}
+	return int(value), true
+}
+
 // GetFloat retrieves a float64 value by key.
+// Returns false if the value isn't a number or is too large for a float64.
 func (c *ConfigMap) GetFloat(key string) (float64, bool) {
-	val, ok := c.Get(key)
+	val, ok := c.lookup(key)
 	if !ok {
 		return 0, false
 	}
@@ -101,6 +239,14 @@ func (c *ConfigMap) GetFloat(key string) (float64, bool) {
 		return float64(v), true
 	case int64:
 		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case *big.Int:
+		float, err := bigIntFloat(v)
+		return float, err == nil
+	case json.Number:
+		float, err := v.Float64()
+		return float, err == nil
 	default:
 		return 0, false
 	}
@@ -108,7 +254,7 @@ func (c *ConfigMap) GetFloat(key string) (float64, bool) {
 
 // GetBool retrieves a boolean value by key.
 func (c *ConfigMap) GetBool(key string) (bool, bool) {
-	val, ok := c.Get(key)
+	val, ok := c.lookup(key)
 	if !ok {
 		return false, false
 	}
@@ -136,52 +282,75 @@ func (c *ConfigMap) GetMap(key string) (map[string]interface{}, bool) {
 	return m, ok
 }
 
-// Set sets a value at the given dot-separated key path.
-// Creates intermediate maps as needed.
+// Set sets a value at the given dot-separated key path, which is written
+// as for Get. Creates intermediate maps as needed, or lists when the next
+// segment is a bracketed index. Setting the index one past the end of a
+// list, or the empty index as in "servers[]", appends to it. Maps and lists
+// in value are copied, so the caller may go on to modify them, and the copy
+// is normalized as NewConfigMap normalizes its data.
 func (c *ConfigMap) Set(key string, value interface{}) error {
 	if key == "" {
 		return fmt.Errorf("key cannot be empty")
 	}
+	value = normalizeValue(copyTree(value), true)
 
-	parts := strings.Split(key, keySeparator)
-	current := c.data
-
-	// Navigate to the parent of the final key, creating maps as needed
-	for i := 0; i < len(parts)-1; i++ {
-		part := parts[i]
-		next, ok := current[part]
-		if !ok {
-			// Create intermediate map
-			newMap := make(map[string]interface{})
-			current[part] = newMap
-			current = newMap
-		} else if nextMap, ok := next.(map[string]interface{}); ok {
-			current = nextMap
-		} else {
-			return fmt.Errorf("cannot set %s: %s is not a map", key, strings.Join(parts[:i+1], keySeparator))
-		}
+	segments, err := c.resolve(key)
+	if err != nil {
+		return err
 	}
 
-	// Set the final value
-	current[parts[len(parts)-1]] = value
+	err = c.update(segments, func(data map[string]interface{}) (map[string]interface{}, error) {
+		updated, err := setPath(data, segments, value, nil)
+		if err != nil {
+			return nil, err
+		}
+		return updated.(map[string]interface{}), nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", key, err)
+	}
 	return nil
 }
 
 // Has checks if a key exists.
 func (c *ConfigMap) Has(key string) bool {
-	_, ok := c.Get(key)
+	_, ok := c.lookup(key)
 	return ok
 }
 
-// Data returns the underlying map.
+// Decode decodes the configuration into dest, which must be a pointer to a
+// struct or map. Nested maps are mapped onto structs using prefer tags, and
+// strings such as those from EnvSource are converted into the types of the
+// fields they're decoded into. Every value which can't be converted is
+// reported, with its key path, in a *DecodeError.
+//
+// WithKeyNaming and WithStrict may be given to control how keys are matched.
+func (c *ConfigMap) Decode(dest interface{}, opts ...Option) error {
+	configuration := NewConfiguration("", opts...)
+	data := c.current()
+	if configuration.strict {
+		if err := checkUnknownKeys(data, dest, tagName, configuration.keyNaming); err != nil {
+			return err
+		}
+	}
+	return decodeTree(data, dest, configuration.keyNaming, true)
+}
+
+// Data returns a copy of the underlying map, which the caller may modify.
+// For views returned by Sub, it is the map at the view's prefix, or an empty
+// map when there is none.
 func (c *ConfigMap) Data() map[string]interface{} {
-	return c.data
+	if data, ok := c.current().(map[string]interface{}); ok {
+		return copyTree(data).(map[string]interface{})
+	}
+	return make(map[string]interface{})
 }
 
 // Keys returns all top-level keys.
 func (c *ConfigMap) Keys() []string {
-	keys := make([]string, 0, len(c.data))
-	for k := range c.data {
+	data, _ := c.current().(map[string]interface{})
+	keys := make([]string, 0, len(data))
+	for k := range data {
 		keys = append(keys, k)
 	}
 	return keys
@@ -1,9 +1,16 @@
 package prefer
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfigMapGet(t *testing.T) {
@@ -363,7 +370,7 @@ func TestConfigMapGetThroughNonMap(t *testing.T) {
 
 func TestNewConfigMapWithNil(t *testing.T) {
 	cm := NewConfigMap(nil)
-	if cm.data == nil {
+	if cm.load() == nil {
 		t.Error("Expected non-nil map even when initialized with nil")
 	}
 
@@ -374,6 +381,34 @@ func TestNewConfigMapWithNil(t *testing.T) {
 	}
 }
 
+func TestNewConfigMapLeavesDataUnchanged(t *testing.T) {
+	nested := map[string]interface{}{"port": 8080}
+	data := map[string]interface{}{
+		"server":  nested,
+		"legacy":  map[interface{}]interface{}{"debug": true},
+		"servers": []map[string]interface{}{{"port": 80}},
+	}
+
+	cm := NewConfigMap(data)
+	if port, _ := cm.Get("server.port"); port != int64(8080) {
+		t.Errorf("Expected the map's values to be normalized, got %#v", port)
+	}
+	if nested["port"] != 8080 || data["server"].(map[string]interface{})["port"] != 8080 {
+		t.Error("Expected the caller's maps to keep their values")
+	}
+	if _, ok := data["legacy"].(map[interface{}]interface{}); !ok {
+		t.Error("Expected the caller's maps to keep their types")
+	}
+	if data["servers"].([]map[string]interface{})[0]["port"] != 80 {
+		t.Error("Expected maps in the caller's lists to keep their values")
+	}
+
+	checkTestError(t, cm.Set("server.port", 9090))
+	if nested["port"] != 8080 {
+		t.Error("Expected changes to the ConfigMap to leave the caller's maps alone")
+	}
+}
+
 func TestConfigMapGetIntWithInt64(t *testing.T) {
 	data := map[string]interface{}{
 		"int64_val": int64(9223372036854775807),
@@ -460,3 +495,408 @@ func TestConfigMapSetThroughExistingMap(t *testing.T) {
 		t.Error("Expected database.host to still be 'localhost'")
 	}
 }
+
+func TestConfigMapDecode(t *testing.T) {
+	type Common struct {
+		Name string `prefer:"name"`
+	}
+	type Config struct {
+		Common
+		Debug    bool          `prefer:"debug"`
+		Timeout  time.Duration `prefer:"timeout"`
+		Ratio    float64       `prefer:"ratio"`
+		Hosts    []string      `prefer:"hosts"`
+		Ports    []int         `prefer:"ports"`
+		Version  string        `prefer:"version"`
+		Database struct {
+			Port int `prefer:"port"`
+		} `prefer:"database"`
+	}
+
+	cm := NewConfigMap(map[string]interface{}{
+		"name":     "app",
+		"debug":    "true",
+		"timeout":  "1m30s",
+		"ratio":    "0.25",
+		"hosts":    "a.example.com, b.example.com",
+		"ports":    []interface{}{"80", int64(443)},
+		"version":  int64(2),
+		"database": map[string]interface{}{"port": "5432"},
+	})
+
+	var config Config
+	if err := cm.Decode(&config); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if config.Name != "app" || !config.Debug || config.Timeout != 90*time.Second || config.Ratio != 0.25 {
+		t.Error("Unexpected scalar values:", config)
+	}
+	if len(config.Hosts) != 2 || config.Hosts[1] != "b.example.com" {
+		t.Error("Expected comma-separated string to become a slice, got:", config.Hosts)
+	}
+	if len(config.Ports) != 2 || config.Ports[0] != 80 || config.Ports[1] != 443 {
+		t.Error("Unexpected ports:", config.Ports)
+	}
+	if config.Version != "2" || config.Database.Port != 5432 {
+		t.Error("Unexpected converted values:", config.Version, config.Database.Port)
+	}
+}
+
+func TestConfigMapDecodeReportsEveryFailure(t *testing.T) {
+	type Config struct {
+		Port    int           `prefer:"port"`
+		Debug   bool          `prefer:"debug"`
+		Timeout time.Duration `prefer:"timeout"`
+		Server  struct {
+			Workers uint8 `prefer:"workers"`
+		} `prefer:"server"`
+	}
+
+	cm := NewConfigMap(map[string]interface{}{
+		"port":    "eighty",
+		"debug":   "maybe",
+		"timeout": "soon",
+		"server":  map[string]interface{}{"workers": "1000"},
+	})
+
+	var config Config
+	err := cm.Decode(&config)
+
+	var decodeError *DecodeError
+	if !errors.As(err, &decodeError) {
+		t.Fatal("Expected DecodeError, got:", err)
+	}
+
+	paths := map[string]bool{}
+	for _, fieldError := range decodeError.Errors {
+		paths[fieldError.Path] = true
+	}
+	for _, path := range []string{"port", "debug", "timeout", "server.workers"} {
+		if !paths[path] {
+			t.Error("Expected failure to be reported for", path)
+		}
+	}
+}
+
+func TestConfigMapDecodeWithOptions(t *testing.T) {
+	type Config struct {
+		MaxConns int
+	}
+
+	cm := NewConfigMap(map[string]interface{}{"max_conns": "10", "extra": true})
+
+	var config Config
+	if err := cm.Decode(&config, WithKeyNaming(SnakeCase)); err != nil || config.MaxConns != 10 {
+		t.Error("Expected snake case key to be decoded, got:", config.MaxConns, err)
+	}
+
+	var unknown *UnknownKeysError
+	if err := cm.Decode(&config, WithKeyNaming(SnakeCase), WithStrict()); !errors.As(err, &unknown) {
+		t.Error("Expected strict decoding to reject unknown key, got:", err)
+	}
+}
+
+func TestConfigMapDecodeCopiesGenericValues(t *testing.T) {
+	var config struct {
+		Db    interface{}
+		Hosts []interface{}
+	}
+
+	cm := NewConfigMap(map[string]interface{}{
+		"db":    map[string]interface{}{"host": "localhost"},
+		"hosts": []interface{}{map[string]interface{}{"name": "a"}},
+	})
+	checkTestError(t, cm.Decode(&config))
+
+	config.Db.(map[string]interface{})["host"] = "changed"
+	config.Hosts[0].(map[string]interface{})["name"] = "changed"
+	if host, _ := cm.GetString("db.host"); host != "localhost" {
+		t.Error("Expected changes to decoded maps to leave the configuration alone, got:", host)
+	}
+	if name, _ := cm.GetString("hosts[0].name"); name != "a" {
+		t.Error("Expected changes to decoded lists to leave the configuration alone, got:", name)
+	}
+}
+
+type testLevel int
+
+func (l *testLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	default:
+		return errors.New("unknown level " + string(text))
+	}
+	return nil
+}
+
+func TestGenericGet(t *testing.T) {
+	cm := NewConfigMap(map[string]interface{}{
+		"port":    "8080",
+		"workers": int64(4),
+		"ratio":   3.9,
+		"whole":   float64(12),
+		"debug":   "true",
+		"timeout": "250ms",
+		"level":   "info",
+		"big":     int64(300),
+		"hosts":   []interface{}{"a", "b"},
+	})
+
+	if port, err := Get[int](cm, "port"); err != nil || port != 8080 {
+		t.Error("Expected string port to be parsed, got:", port, err)
+	}
+	if workers, err := Get[uint16](cm, "workers"); err != nil || workers != 4 {
+		t.Error("Expected int64 to convert to uint16, got:", workers, err)
+	}
+	if whole, err := Get[int32](cm, "whole"); err != nil || whole != 12 {
+		t.Error("Expected integral float to convert, got:", whole, err)
+	}
+	if ratio, err := Get[float32](cm, "ratio"); err != nil || ratio != float32(3.9) {
+		t.Error("Expected float32, got:", ratio, err)
+	}
+	if debug, err := Get[bool](cm, "debug"); err != nil || !debug {
+		t.Error("Expected string bool to be parsed, got:", debug, err)
+	}
+	if timeout, err := Get[time.Duration](cm, "timeout"); err != nil || timeout != 250*time.Millisecond {
+		t.Error("Expected duration to be parsed, got:", timeout, err)
+	}
+	if level, err := Get[testLevel](cm, "level"); err != nil || level != 1 {
+		t.Error("Expected TextUnmarshaler to be used, got:", level, err)
+	}
+	if hosts, err := Get[[]string](cm, "hosts"); err != nil || len(hosts) != 2 {
+		t.Error("Expected slice, got:", hosts, err)
+	}
+	if workers, err := Get[string](cm, "workers"); err != nil || workers != "4" {
+		t.Error("Expected number to be formatted as string, got:", workers, err)
+	}
+}
+
+func TestGenericGetErrors(t *testing.T) {
+	cm := NewConfigMap(map[string]interface{}{
+		"ratio":   3.9,
+		"big":     int64(300),
+		"level":   "verbose",
+		"name":    "app",
+		"precise": int64(9007199254740993),
+		"huge":    new(big.Int).Lsh(big.NewInt(1), 64),
+		"odd":     new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 64), big.NewInt(1)),
+	})
+
+	var fieldError *FieldError
+
+	if _, err := Get[int](cm, "ratio"); !errors.As(err, &fieldError) || fieldError.Path != "ratio" {
+		t.Error("Expected lossy float conversion to fail, got:", err)
+	}
+	if _, err := Get[int8](cm, "big"); !errors.As(err, &fieldError) {
+		t.Error("Expected overflow to fail, got:", err)
+	}
+	if _, err := Get[float64](cm, "precise"); !errors.As(err, &fieldError) || fieldError.Path != "precise" {
+		t.Error("Expected an integer a float64 can't represent exactly to fail, got:", err)
+	}
+	if _, err := Get[float64](cm, "odd"); !errors.As(err, &fieldError) {
+		t.Error("Expected a big integer a float64 can't represent exactly to fail, got:", err)
+	}
+	if huge, err := Get[float64](cm, "huge"); err != nil || huge != 1<<64 {
+		t.Error("Expected a big integer a float64 can represent to convert, got:", huge, err)
+	}
+	if _, err := Get[testLevel](cm, "level"); err == nil || !strings.Contains(err.Error(), "unknown level verbose") {
+		t.Error("Expected TextUnmarshaler error, got:", err)
+	}
+	if _, err := Get[int](cm, "name"); err == nil || !strings.Contains(err.Error(), "name: cannot decode string app into int") {
+		t.Error("Expected descriptive error, got:", err)
+	}
+	if _, err := Get[int](cm, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Error("Expected ErrKeyNotFound, got:", err)
+	}
+}
+
+func TestGenericGetOr(t *testing.T) {
+	cm := NewConfigMap(map[string]interface{}{"port": "8080", "name": "app"})
+
+	if port := GetOr(cm, "port", 80); port != 8080 {
+		t.Error("Expected configured port, got:", port)
+	}
+	if port := GetOr(cm, "missing", 80); port != 80 {
+		t.Error("Expected fallback for missing key, got:", port)
+	}
+	if port := GetOr(cm, "name", 80); port != 80 {
+		t.Error("Expected fallback for failed conversion, got:", port)
+	}
+}
+
+func TestConfigMapTypedAccessors(t *testing.T) {
+	cm := NewConfigMap(map[string]interface{}{
+		"timeout": "30s",
+		"limits":  map[string]interface{}{"max_body": "10MiB", "max_header": int64(8192)},
+		"api":     "https://example.com:8443/v1",
+		"listen":  "::1",
+		"network": "10.1.0.0/16",
+		"pattern": "^foo",
+		"expires": "2030-01-02T03:04:05Z",
+		"broken":  "%zz",
+	})
+
+	if timeout, err := cm.GetDuration("timeout"); err != nil || timeout != 30*time.Second {
+		t.Error("Unexpected duration:", timeout, err)
+	}
+	if size, err := cm.GetByteSize("limits.max_body"); err != nil || size != 10<<20 {
+		t.Error("Unexpected byte size:", size, err)
+	}
+	if size, err := cm.GetByteSize("limits.max_header"); err != nil || size != 8192 {
+		t.Error("Expected plain number of bytes, got:", size, err)
+	}
+	if api, err := cm.GetURL("api"); err != nil || api.Port() != "8443" {
+		t.Error("Unexpected URL:", api, err)
+	}
+	if _, err := cm.GetURL("broken"); err == nil {
+		t.Error("Expected invalid URL to fail")
+	}
+	if ip, err := cm.GetIP("listen"); err != nil || !ip.IsLoopback() {
+		t.Error("Unexpected IP:", ip, err)
+	}
+	if network, err := cm.GetCIDR("network"); err != nil || network.String() != "10.1.0.0/16" {
+		t.Error("Unexpected network:", network, err)
+	}
+	if pattern, err := cm.GetRegexp("pattern"); err != nil || pattern.MatchString("barfoo") {
+		t.Error("Unexpected pattern:", pattern, err)
+	}
+	if expires, err := cm.GetTime("expires"); err != nil || expires.Year() != 2030 {
+		t.Error("Unexpected time:", expires, err)
+	}
+	if _, err := cm.GetDuration("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Error("Expected missing key error, got:", err)
+	}
+}
+
+func TestConfigMapGetKeyPaths(t *testing.T) {
+	cm := NewConfigMap(map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"host": "a"},
+			map[string]interface{}{"host": "b"},
+		},
+		"hosts": map[string]interface{}{
+			"example.com": map[string]interface{}{"ip": "10.0.0.1"},
+			"0":           "numbered",
+		},
+	})
+
+	expected := map[string]interface{}{
+		"servers.0.host":          "a",
+		"servers[1].host":         "b",
+		"servers[-1].host":        "b",
+		"servers.-2.host":         "a",
+		`hosts."example.com".ip`:  "10.0.0.1",
+		`hosts["example.com"].ip`: "10.0.0.1",
+		`hosts.example\.com.ip`:   "10.0.0.1",
+		"hosts.0":                 "numbered",
+	}
+	for key, value := range expected {
+		got, ok := cm.Get(key)
+		if !ok || got != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, got)
+		}
+	}
+
+	for _, key := range []string{"servers.2.host", "servers[-3]", "servers.x", "hosts[0]", "hosts.example.com", "servers[]", "a..b"} {
+		if cm.Has(key) {
+			t.Errorf("Expected %s to be missing", key)
+		}
+	}
+}
+
+func TestConfigMapSetKeyPaths(t *testing.T) {
+	cm := NewConfigMap(map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"host": "a"},
+		},
+	})
+
+	checkTestError(t, cm.Set("servers.0.host", "changed"))
+	checkTestError(t, cm.Set("servers[1].host", "appended"))
+	checkTestError(t, cm.Set("servers[].host", "appended again"))
+	checkTestError(t, cm.Set("servers[-1].port", 80))
+	checkTestError(t, cm.Set(`hosts."example.com"`, "10.0.0.1"))
+	checkTestError(t, cm.Set("matrix[][]", 1))
+
+	expected := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"host": "changed"},
+			map[string]interface{}{"host": "appended"},
+			map[string]interface{}{"host": "appended again", "port": int64(80)},
+		},
+		"hosts": map[string]interface{}{
+			"example.com": "10.0.0.1",
+		},
+		"matrix": []interface{}{[]interface{}{int64(1)}},
+	}
+	if !reflect.DeepEqual(cm.Data(), expected) {
+		t.Errorf("Expected %v, got %v", expected, cm.Data())
+	}
+
+	if err := cm.Set("servers.5", "x"); err == nil {
+		t.Error("Expected an error setting past the end of a list")
+	}
+	if err := cm.Set("servers.name", "x"); err == nil {
+		t.Error("Expected an error setting a key on a list")
+	}
+	if err := cm.Set("hosts[0]", "x"); err == nil {
+		t.Error("Expected an error indexing a map")
+	}
+	if err := cm.Set("a..b", "x"); err == nil {
+		t.Error("Expected an error for an invalid key path")
+	}
+}
+
+func TestLoadMapWithExactNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"id": 9007199254740993, "huge": 99999999999999999999, "ratio": 0.1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := LoadMap(path, WithExactNumbers())
+	checkTestError(t, err)
+
+	if id, ok := cm.GetInt("id"); !ok || id != 9007199254740993 {
+		t.Errorf("Expected id to be exact, got %v", id)
+	}
+	if _, ok := cm.GetInt("huge"); ok {
+		t.Error("Expected GetInt to fail for a value which overflows int")
+	}
+	huge, err := Get[*big.Int](cm, "huge")
+	checkTestError(t, err)
+	if huge.String() != "99999999999999999999" {
+		t.Errorf("Expected huge to be exact, got %s", huge)
+	}
+	if ratio, ok := cm.GetFloat("ratio"); !ok || ratio != 0.1 {
+		t.Errorf("Expected ratio to be 0.1, got %v", ratio)
+	}
+	if ratio, err := Get[json.Number](cm, "ratio"); err != nil || ratio != "0.1" {
+		t.Errorf("Expected ratio to be json.Number 0.1, got %v (%v)", ratio, err)
+	}
+}
+
+func TestConfigMapGetIntOverflow(t *testing.T) {
+	cm := NewConfigMap(map[string]interface{}{
+		"uint":    uint64(math.MaxUint64),
+		"float":   1e300,
+		"nan":     math.NaN(),
+		"number":  json.Number("42"),
+		"decimal": json.Number("4.5"),
+	})
+
+	for _, key := range []string{"uint", "float", "nan"} {
+		if _, ok := cm.GetInt(key); ok {
+			t.Errorf("Expected GetInt to fail for %s", key)
+		}
+	}
+	if value, ok := cm.GetInt("number"); !ok || value != 42 {
+		t.Errorf("Expected number to be 42, got %v", value)
+	}
+	if value, ok := cm.GetInt("decimal"); !ok || value != 4 {
+		t.Errorf("Expected decimal to truncate to 4, got %v", value)
+	}
+}
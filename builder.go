@@ -1,7 +1,13 @@
 package prefer
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -13,34 +19,20 @@ type Source interface {
 
 // DeepMerge merges override into base, returning a new map.
 // Nested maps are merged recursively; other values are overwritten.
+// As in an RFC 7396 merge patch, a nil value in override removes the key.
 func DeepMerge(base, override map[string]interface{}) map[string]interface{} {
-	result := make(map[string]interface{})
-
-	// Copy base
-	for k, v := range base {
-		result[k] = v
-	}
-
-	// Merge override
-	for k, v := range override {
-		if baseVal, exists := result[k]; exists {
-			baseMap, baseIsMap := baseVal.(map[string]interface{})
-			overrideMap, overrideIsMap := v.(map[string]interface{})
-			if baseIsMap && overrideIsMap {
-				result[k] = DeepMerge(baseMap, overrideMap)
-				continue
-			}
-		}
-		result[k] = v
-	}
-
-	return result
+	return DeepMergeWith(base, override)
 }
 
 // ConfigBuilder builds configuration from multiple layered sources.
 // Sources are applied in order, with later sources overriding earlier ones.
+// Files may use merge directives, such as "plugins+", to say how they are
+// merged; see WithMergeDirectives.
 type ConfigBuilder struct {
-	sources []Source
+	sources       []Source
+	collectErrors bool
+	exactNumbers  bool
+	mergeOptions  []MergeOption
 }
 
 // NewConfigBuilder creates a new ConfigBuilder.
@@ -75,28 +67,160 @@ func (b *ConfigBuilder) AddOptionalFile(identifier string) *ConfigBuilder {
 // AddEnv adds environment variables with the given prefix.
 // Variables are converted to nested structure using the separator.
 // Example: MYAPP__DATABASE__HOST with prefix "MYAPP" becomes database.host
-func (b *ConfigBuilder) AddEnv(prefix string) *ConfigBuilder {
-	return b.AddSource(&EnvSource{prefix: prefix, separator: "__"})
+// Values are converted to the types set by earlier sources; see EnvSource.
+func (b *ConfigBuilder) AddEnv(prefix string, opts ...EnvOption) *ConfigBuilder {
+	return b.AddSource(NewEnvSource(prefix, opts...))
 }
 
 // AddEnvWithSeparator adds environment variables with a custom separator.
-func (b *ConfigBuilder) AddEnvWithSeparator(prefix, separator string) *ConfigBuilder {
-	return b.AddSource(&EnvSource{prefix: prefix, separator: separator})
+func (b *ConfigBuilder) AddEnvWithSeparator(prefix, separator string, opts ...EnvOption) *ConfigBuilder {
+	return b.AddSource(NewEnvSourceWithSeparator(prefix, separator, opts...))
 }
 
-// Build loads and merges all sources, returning a ConfigMap.
+// AddFlags adds the flags of a parsed flag.FlagSet which were set on the
+// command line; see FlagSource.
+func (b *ConfigBuilder) AddFlags(flags *flag.FlagSet) *ConfigBuilder {
+	return b.AddSource(NewFlagSource(flags))
+}
+
+// FlagSet returns a flag.FlagSet for the fields of sample, as NewFlagSet
+// does, whose defaults are the configuration built from the sources added so
+// far. Those sources are loaded again by Build.
+func (b *ConfigBuilder) FlagSet(name string, handling flag.ErrorHandling, sample interface{}, opts ...Option) (*flag.FlagSet, error) {
+	defaults, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return NewFlagSet(name, handling, sample, defaults, opts...), nil
+}
+
+// CollectErrors makes Build load every source even after one fails, and
+// report all of the failures together in a *BuildError.
+func (b *ConfigBuilder) CollectErrors() *ConfigBuilder {
+	b.collectErrors = true
+	return b
+}
+
+// ExactNumbers keeps numbers from every source exact, as WithExactNumbers
+// does for Load.
+func (b *ConfigBuilder) ExactNumbers() *ConfigBuilder {
+	b.exactNumbers = true
+	return b
+}
+
+// MergeStrategy sets how values at path from later sources are combined
+// with those from earlier ones, such as MergeAppend for a list of plugins
+// which every source adds to. See WithMergeStrategy for how paths match.
+func (b *ConfigBuilder) MergeStrategy(path string, strategy MergeStrategy) *ConfigBuilder {
+	b.mergeOptions = append(b.mergeOptions, WithMergeStrategy(path, strategy))
+	return b
+}
+
+// Build loads and merges all sources, returning a ConfigMap. Where each
+// value came from is recorded for ConfigMap.Explain.
+// Failures are returned as a *SourceError, or as a *BuildError when
+// CollectErrors has been called.
 func (b *ConfigBuilder) Build() (*ConfigMap, error) {
 	merged := make(map[string]interface{})
+	history := make(map[string][]Origin)
+	var failures []*SourceError
 
-	for _, source := range b.sources {
-		data, err := source.Load()
+	var options []Option
+	if b.exactNumbers {
+		options = append(options, WithExactNumbers())
+	}
+
+	for index, source := range b.sources {
+		loaded, err := loadSource(source, options, merged)
 		if err != nil {
-			return nil, err
+			sourceError := &SourceError{Index: index, Name: sourceName(source), Err: err}
+			if !b.collectErrors {
+				return nil, sourceError
+			}
+			failures = append(failures, sourceError)
+			continue
+		}
+		// Sources such as MemorySource may share their data with the caller,
+		// so it is copied rather than normalized in place
+		data := normalizeMap(copyTree(loaded.data).(map[string]interface{}), b.exactNumbers)
+		previous := merged
+		mergeOptions := b.mergeOptions
+		switch source.(type) {
+		case *FileSource:
+			mergeOptions = append(mergeOptions[:len(mergeOptions):len(mergeOptions)], WithMergeDirectives())
+		case *EnvSource, *FlagSource:
+			mergeOptions = append(mergeOptions[:len(mergeOptions):len(mergeOptions)], withListIndexes())
 		}
-		merged = DeepMerge(merged, data)
+		merged = DeepMergeWith(merged, data, mergeOptions...)
+		recordOrigins(history, previous, merged, data, loaded)
 	}
 
-	return &ConfigMap{data: merged}, nil
+	if len(failures) > 0 {
+		return nil, &BuildError{Errors: failures}
+	}
+
+	config := newConfigMap(merged)
+	config.origins = history
+	return config, nil
+}
+
+// BuildInto builds the configuration and decodes it into dest.
+// See ConfigMap.Decode for how values are converted.
+func (b *ConfigBuilder) BuildInto(dest interface{}, opts ...Option) (*ConfigMap, error) {
+	config, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Decode(dest, opts...); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// SourceError is a failure to load one of a ConfigBuilder's sources.
+// Index is the position of the source in the order it was added.
+type SourceError struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("source %d (%s): %v", e.Index, e.Name, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// BuildError reports every source which failed to load when a ConfigBuilder
+// collects errors.
+type BuildError struct {
+	Errors []*SourceError
+}
+
+func (e *BuildError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d configuration sources failed: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+func (e *BuildError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// sourceName describes a source for error messages.
+func sourceName(source Source) string {
+	if stringer, ok := source.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", source)
 }
 
 // MemorySource provides configuration from an in-memory map.
@@ -109,62 +233,144 @@ func NewMemorySource(data map[string]interface{}) *MemorySource {
 	return &MemorySource{data: data}
 }
 
+func (s *MemorySource) String() string {
+	return "memory"
+}
+
 func (s *MemorySource) Load() (map[string]interface{}, error) {
-	// Return a copy to prevent mutation
-	result := make(map[string]interface{})
-	for k, v := range s.data {
-		result[k] = v
+	// Return a deep copy, so that neither the caller nor the result can
+	// change the other's nested maps and lists
+	result, _ := copyTree(s.data).(map[string]interface{})
+	if result == nil {
+		result = make(map[string]interface{})
 	}
 	return result, nil
 }
 
+// originSource is implemented by sources which can say where each of their
+// values came from, or which depend on the builder: files, so that options
+// given to it such as ExactNumbers apply to them, and the environment and
+// flags, whose values are converted to the types of the values from lower
+// layers.
+type originSource interface {
+	loadSource(opts []Option, lower map[string]interface{}) (*loadedSource, error)
+}
+
 // FileSource loads configuration from a file.
 type FileSource struct {
 	identifier string
 	required   bool
+	options    []Option
+}
+
+// NewFileSource creates a required file source. Options are passed to Load.
+func NewFileSource(identifier string, opts ...Option) *FileSource {
+	return &FileSource{identifier: identifier, required: true, options: opts}
 }
 
-// NewFileSource creates a required file source.
-func NewFileSource(identifier string) *FileSource {
-	return &FileSource{identifier: identifier, required: true}
+// NewOptionalFileSource creates an optional file source. Options are passed
+// to Load.
+func NewOptionalFileSource(identifier string, opts ...Option) *FileSource {
+	return &FileSource{identifier: identifier, required: false, options: opts}
 }
 
-// NewOptionalFileSource creates an optional file source.
-func NewOptionalFileSource(identifier string) *FileSource {
-	return &FileSource{identifier: identifier, required: false}
+func (s *FileSource) String() string {
+	if s.required {
+		return "file " + s.identifier
+	}
+	return "optional file " + s.identifier
 }
 
 func (s *FileSource) Load() (map[string]interface{}, error) {
-	var result map[string]interface{}
-	_, err := Load(s.identifier, &result)
+	loaded, err := s.loadSource(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return loaded.data, nil
+}
+
+func (s *FileSource) loadSource(opts []Option, _ map[string]interface{}) (*loadedSource, error) {
+	options := append(append([]Option{}, s.options...), opts...)
+	configuration := NewConfiguration(s.identifier, options...)
+
+	identifier, content, err := configuration.load()
 	if err != nil {
-		if !s.required {
-			// Return empty map for optional files that don't exist
-			return make(map[string]interface{}), nil
+		// Optional files may be missing, but a file which exists and can't
+		// be read or parsed is always an error.
+		if !s.required && errors.Is(err, ErrNotFound) {
+			return &loadedSource{data: make(map[string]interface{})}, nil
 		}
 		return nil, err
 	}
-	return result, nil
+
+	var result map[string]interface{}
+	configuration.Identifier = identifier
+	if err := configuration.decode(identifier, content, &result); err != nil {
+		return nil, err
+	}
+
+	origin := Origin{Source: "file", Name: identifier}
+	origins := make(map[string]Origin)
+	for path, line := range sourceLines(identifier, content) {
+		origins[path] = Origin{Source: "file", Name: identifier, Line: line}
+	}
+	return &loadedSource{data: result, origin: origin, origins: origins}, nil
 }
 
 // EnvSource loads configuration from environment variables.
+//
+// Values are converted from text to the type of the value which sources
+// before it in a ConfigBuilder set for the same key, or to the type of the
+// field given by WithEnvType: bools, numbers and durations are parsed, lists
+// are split on commas or parsed as JSON, and maps are parsed as JSON. Values
+// which can't be converted are an error. Values with no known type remain
+// strings.
+//
+// Numbered variables such as PREFIX__SERVERS__1__PORT set the items of lists
+// from earlier sources, leaving their other items and fields alone, or add
+// items after their end. Numbers which would leave a gap are an error.
 type EnvSource struct {
-	prefix    string
-	separator string
+	prefix        string
+	separator     string
+	sampleType    reflect.Type
+	listSeparator string
+	keyMapper     func(string) string
 }
 
 // NewEnvSource creates a new EnvSource with the default separator "__".
-func NewEnvSource(prefix string) *EnvSource {
-	return &EnvSource{prefix: prefix, separator: "__"}
+func NewEnvSource(prefix string, opts ...EnvOption) *EnvSource {
+	return NewEnvSourceWithSeparator(prefix, "__", opts...)
 }
 
 // NewEnvSourceWithSeparator creates an EnvSource with a custom separator.
-func NewEnvSourceWithSeparator(prefix, separator string) *EnvSource {
-	return &EnvSource{prefix: prefix, separator: separator}
+func NewEnvSourceWithSeparator(prefix, separator string, opts ...EnvOption) *EnvSource {
+	s := &EnvSource{
+		prefix:        prefix,
+		separator:     separator,
+		listSeparator: ",",
+		keyMapper:     strings.ToLower,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *EnvSource) String() string {
+	return "environment " + s.prefix + s.separator + "*"
 }
 
 func (s *EnvSource) Load() (map[string]interface{}, error) {
+	loaded, err := s.loadSource(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return loaded.data, nil
+}
+
+func (s *EnvSource) loadSource(opts []Option, lower map[string]interface{}) (*loadedSource, error) {
 	result := make(map[string]interface{})
+	origins := make(map[string]Origin)
 	prefix := s.prefix + s.separator
 
 	for _, env := range os.Environ() {
@@ -172,21 +378,118 @@ func (s *EnvSource) Load() (map[string]interface{}, error) {
 		if len(parts) != 2 {
 			continue
 		}
-		key, value := parts[0], parts[1]
+		name, text := parts[0], parts[1]
 
-		if !strings.HasPrefix(key, prefix) {
+		if !strings.HasPrefix(name, prefix) {
 			continue
 		}
 
 		// Remove prefix and convert to nested structure
-		key = strings.TrimPrefix(key, prefix)
-		key = strings.ToLower(key)
-		keyParts := strings.Split(key, s.separator)
-
+		keyParts, target := s.envKeys(strings.Split(strings.TrimPrefix(name, prefix), s.separator), lower)
+		value, err := coerceText(text, target, s.listSeparator)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %s: cannot convert %q to %s: %w", name, text, target, err)
+		}
 		setNested(result, keyParts, value)
+
+		path := make([]string, len(keyParts))
+		for index, part := range keyParts {
+			path[index] = formatKeySegment(part)
+		}
+		origins[strings.Join(path, keySeparator)] = Origin{Source: "env", Name: name}
 	}
 
-	return result, nil
+	// Numbered variables such as PREFIX__SERVERS__0__HOST address list
+	// elements, as "servers.0.host" does in a key path
+	data, err := numberedChildrenToLists(result, lower)
+	if err != nil {
+		return nil, err
+	}
+	return &loadedSource{
+		data:    data,
+		origin:  Origin{Source: "env"},
+		origins: origins,
+	}, nil
+}
+
+// numberedChildrenToLists applies numberedMapsToLists to the values of data,
+// which itself always remains a map, given the values which lower layers
+// have at the same keys.
+func numberedChildrenToLists(data, lower map[string]interface{}) (map[string]interface{}, error) {
+	for key, value := range data {
+		converted, err := numberedMapsToLists([]string{formatKeySegment(key)}, value, lower[key])
+		if err != nil {
+			return nil, err
+		}
+		data[key] = converted
+	}
+	return data, nil
+}
+
+// numberedMapsToLists replaces maps below data whose keys are exactly the
+// numbers 0 to n-1 with lists of their values in order. Numbered maps where
+// lower has a list are kept, so that merging them changes only the items
+// they number, and must number items of that list or continue it without a
+// gap.
+func numberedMapsToLists(path []string, data, lower interface{}) (interface{}, error) {
+	node, ok := data.(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+	for key, value := range node {
+		child := append(path[:len(path):len(path)], formatKeySegment(key))
+		_, existing, _ := lowerKey(key, lower)
+		converted, err := numberedMapsToLists(child, value, existing)
+		if err != nil {
+			return nil, err
+		}
+		node[key] = converted
+	}
+
+	if list, ok := lower.([]interface{}); ok {
+		if _, err := listIndexes(node, len(list)); err != nil {
+			return nil, fmt.Errorf("cannot set %s: %w", strings.Join(path, keySeparator), err)
+		}
+		return node, nil
+	}
+
+	items := make([]interface{}, len(node))
+	for key, value := range node {
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || index >= len(node) || strconv.Itoa(index) != key {
+			return node, nil
+		}
+		items[index] = value
+	}
+	if len(items) == 0 {
+		return node, nil
+	}
+	return items, nil
+}
+
+// listIndexes returns the indexes numbering the keys of node in order, when
+// every key is a number, and an error when they leave a gap after the end
+// of a list of the given length. It returns nil when any key isn't a
+// number.
+func listIndexes(node map[string]interface{}, length int) ([]int, error) {
+	indexes := make([]int, 0, len(node))
+	for key := range node {
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || strconv.Itoa(index) != key {
+			return nil, nil
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		if index > length {
+			return nil, fmt.Errorf("index %d is outside a list of length %d", index, length)
+		}
+		if index == length {
+			length++
+		}
+	}
+	return indexes, nil
 }
 
 // setNested sets a value in a nested map structure.
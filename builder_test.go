@@ -1,9 +1,15 @@
 package prefer
 
 import (
+	"errors"
+	"math"
+	"math/big"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDeepMerge(t *testing.T) {
@@ -57,6 +63,34 @@ func TestDeepMergeNonMapOverride(t *testing.T) {
 	}
 }
 
+func TestDeepMergeNilDeletes(t *testing.T) {
+	base := map[string]interface{}{
+		"database": map[string]interface{}{"host": "localhost", "port": 5432},
+		"debug":    true,
+	}
+
+	override := map[string]interface{}{
+		"database": map[string]interface{}{"port": nil},
+		"debug":    nil,
+		"cache":    map[string]interface{}{"host": nil},
+	}
+
+	result := DeepMerge(base, override)
+	if _, ok := result["debug"]; ok {
+		t.Error("Expected nil to remove debug")
+	}
+	database := result["database"].(map[string]interface{})
+	if _, ok := database["port"]; ok || database["host"] != "localhost" {
+		t.Errorf("Expected nil to remove only the nested port, got %v", database)
+	}
+	if cache := result["cache"].(map[string]interface{}); len(cache) != 0 {
+		t.Errorf("Expected nil values in new maps to be dropped, got %v", cache)
+	}
+	if _, ok := base["debug"]; !ok {
+		t.Error("Expected base to be unchanged")
+	}
+}
+
 func TestConfigBuilderWithDefaults(t *testing.T) {
 	builder := NewConfigBuilder().
 		AddDefaults(map[string]interface{}{
@@ -210,6 +244,217 @@ func TestEnvSourceLoad(t *testing.T) {
 	}
 }
 
+func TestEnvSourceLoadLists(t *testing.T) {
+	os.Setenv("TESTLIST__SERVERS__1__HOST", "b")
+	os.Setenv("TESTLIST__SERVERS__0__HOST", "a")
+	os.Setenv("TESTLIST__PORTS__80", "http")
+	defer func() {
+		os.Unsetenv("TESTLIST__SERVERS__1__HOST")
+		os.Unsetenv("TESTLIST__SERVERS__0__HOST")
+		os.Unsetenv("TESTLIST__PORTS__80")
+	}()
+
+	data, err := NewEnvSource("TESTLIST").Load()
+	checkTestError(t, err)
+
+	cm := NewConfigMap(data)
+	if host, _ := cm.GetString("servers[1].host"); host != "b" {
+		t.Error("Expected numbered variables to become list elements")
+	}
+	if _, ok := cm.GetMap("ports"); !ok {
+		t.Error("Expected sparse numbered variables to remain a map")
+	}
+}
+
+func TestConfigBuilderEnvCoercesToLowerLayers(t *testing.T) {
+	variables := map[string]string{
+		"TESTCOERCE__DEBUG":          "true",
+		"TESTCOERCE__PORT":           "9090",
+		"TESTCOERCE__RATIO":          "0.5",
+		"TESTCOERCE__HOSTS":          "a, b",
+		"TESTCOERCE__PORTS":          "[80, 443]",
+		"TESTCOERCE__LIMITS":         `{"rate": 10}`,
+		"TESTCOERCE__MAXBODYSIZE":    "1024",
+		"TESTCOERCE__NAME":           "42",
+		"TESTCOERCE__DATABASE__HOST": "db",
+	}
+	for name, value := range variables {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	config, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{
+			"debug":       false,
+			"port":        8080,
+			"ratio":       1.0,
+			"hosts":       []interface{}{"localhost"},
+			"ports":       []interface{}{},
+			"limits":      map[string]interface{}{"rate": 1},
+			"maxBodySize": 1,
+			"name":        "app",
+		}).
+		AddEnv("TESTCOERCE").
+		Build()
+	checkTestError(t, err)
+
+	expected := map[string]interface{}{
+		"debug":       true,
+		"port":        int64(9090),
+		"ratio":       0.5,
+		"hosts":       []interface{}{"a", "b"},
+		"ports":       []interface{}{int64(80), int64(443)},
+		"limits":      map[string]interface{}{"rate": int64(10)},
+		"maxBodySize": int64(1024),
+		"name":        "42",
+		"database":    map[string]interface{}{"host": "db"},
+	}
+	if !reflect.DeepEqual(config.Data(), expected) {
+		t.Errorf("Expected %v, got %v", expected, config.Data())
+	}
+}
+
+func TestEnvSourceWithEnvType(t *testing.T) {
+	type Settings struct {
+		Timeout     time.Duration `prefer:"timeout"`
+		MaxBodySize int           `prefer:"max_body_size"`
+		Tags        []string
+		Weights     []float64 `prefer:"weights"`
+		Server      struct {
+			Secure bool `prefer:"secure"`
+		} `prefer:"server"`
+	}
+
+	variables := map[string]string{
+		"TESTTYPED__TIMEOUT":        "30s",
+		"TESTTYPED__MAX_BODY_SIZE":  "2048",
+		"TESTTYPED__TAGS":           "a;b",
+		"TESTTYPED__WEIGHTS":        "1.5;2",
+		"TESTTYPED__SERVER__SECURE": "1",
+	}
+	for name, value := range variables {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	data, err := NewEnvSource("TESTTYPED", WithEnvType(&Settings{}), WithEnvListSeparator(";")).Load()
+	checkTestError(t, err)
+
+	expected := map[string]interface{}{
+		"timeout":       "30s",
+		"max_body_size": int64(2048),
+		"Tags":          []interface{}{"a", "b"},
+		"weights":       []interface{}{1.5, 2.0},
+		"server":        map[string]interface{}{"secure": true},
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected %v, got %v", expected, data)
+	}
+
+	var settings Settings
+	checkTestError(t, NewConfigMap(data).Decode(&settings))
+	if settings.Timeout != 30*time.Second || !settings.Server.Secure || len(settings.Tags) != 2 {
+		t.Errorf("Expected the converted values to decode, got %+v", settings)
+	}
+}
+
+func TestEnvSourceCoercionErrors(t *testing.T) {
+	type Settings struct {
+		Timeout time.Duration
+		Debug   bool
+		Limits  map[string]int
+	}
+
+	for name, value := range map[string]string{
+		"TESTBADENV__TIMEOUT": "soon",
+		"TESTBADENV__DEBUG":   "maybe",
+		"TESTBADENV__LIMITS":  "rate=10",
+	} {
+		os.Setenv(name, value)
+		_, err := NewEnvSource("TESTBADENV", WithEnvType(Settings{})).Load()
+		os.Unsetenv(name)
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected an error naming %s, got %v", name, err)
+		}
+	}
+}
+
+func TestEnvSourceKeyMapper(t *testing.T) {
+	os.Setenv("TESTMAPPER__LogLevel", "debug")
+	defer os.Unsetenv("TESTMAPPER__LogLevel")
+
+	data, err := NewEnvSource("TESTMAPPER", WithEnvKeyMapper(nil)).Load()
+	checkTestError(t, err)
+	if data["LogLevel"] != "debug" {
+		t.Errorf("Expected names to be kept as they are, got %v", data)
+	}
+
+	data, err = NewEnvSource("TESTMAPPER", WithEnvKeyMapper(strings.ToUpper)).Load()
+	checkTestError(t, err)
+	if data["LOGLEVEL"] != "debug" {
+		t.Errorf("Expected names to be mapped, got %v", data)
+	}
+}
+
+func TestConfigBuilderEnvSetsListItems(t *testing.T) {
+	os.Setenv("TESTITEMS__SERVERS__1__PORT", "9090")
+	os.Setenv("TESTITEMS__SERVERS__2__HOST", "c")
+	defer os.Unsetenv("TESTITEMS__SERVERS__1__PORT")
+	defer os.Unsetenv("TESTITEMS__SERVERS__2__HOST")
+
+	defaults := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"host": "a", "port": 1},
+			map[string]interface{}{"host": "b", "port": 2},
+		},
+	}
+	config, err := NewConfigBuilder().AddDefaults(defaults).AddEnv("TESTITEMS").Build()
+	checkTestError(t, err)
+
+	expected := []interface{}{
+		map[string]interface{}{"host": "a", "port": int64(1)},
+		map[string]interface{}{"host": "b", "port": int64(9090)},
+		map[string]interface{}{"host": "c"},
+	}
+	if servers, _ := config.GetSlice("servers"); !reflect.DeepEqual(servers, expected) {
+		t.Errorf("Expected numbered variables to change only the items they number, got %v", servers)
+	}
+
+	os.Setenv("TESTITEMS__SERVERS__4__HOST", "e")
+	defer os.Unsetenv("TESTITEMS__SERVERS__4__HOST")
+	_, err = NewConfigBuilder().AddDefaults(defaults).AddEnv("TESTITEMS").Build()
+	if err == nil || !strings.Contains(err.Error(), "servers") {
+		t.Errorf("Expected an error for an index which leaves a gap, got %v", err)
+	}
+}
+
+func TestEnvSourceLoadNumberedTopLevel(t *testing.T) {
+	os.Setenv("TESTROOT__0", "zero")
+	defer os.Unsetenv("TESTROOT__0")
+
+	data, err := NewEnvSource("TESTROOT").Load()
+	checkTestError(t, err)
+	if data["0"] != "zero" {
+		t.Errorf("Expected numbered top-level variables to remain keys, got %v", data)
+	}
+}
+
+func TestConfigBuilderLeavesSourceDataUnchanged(t *testing.T) {
+	defaults := map[string]interface{}{
+		"database": map[string]interface{}{"port": 5432},
+		"ports":    []interface{}{80},
+	}
+	_, err := NewConfigBuilder().AddDefaults(defaults).Build()
+	checkTestError(t, err)
+
+	if _, ok := defaults["database"].(map[string]interface{})["port"].(int); !ok {
+		t.Error("Expected Build not to normalize the caller's nested maps")
+	}
+	if _, ok := defaults["ports"].([]interface{})[0].(int); !ok {
+		t.Error("Expected Build not to normalize the caller's lists")
+	}
+}
+
 func TestMemorySourceLoad(t *testing.T) {
 	source := NewMemorySource(map[string]interface{}{
 		"key": "value",
@@ -232,6 +477,34 @@ func TestMemorySourceLoad(t *testing.T) {
 	}
 }
 
+func TestMemorySourceChangesAfterBuild(t *testing.T) {
+	defaults := map[string]interface{}{
+		"list":     []interface{}{"a"},
+		"database": map[string]interface{}{"host": "localhost"},
+	}
+	config, err := NewConfigBuilder().AddDefaults(defaults).Build()
+	checkTestError(t, err)
+	snapshot := config.Snapshot()
+
+	defaults["list"].([]interface{})[0] = "changed"
+	defaults["database"].(map[string]interface{})["host"] = "changed"
+	data, err := NewMemorySource(defaults).Load()
+	checkTestError(t, err)
+	data["database"].(map[string]interface{})["host"] = "loaded"
+
+	for _, c := range []*ConfigMap{config, snapshot} {
+		if item, _ := c.Get("list[0]"); item != "a" {
+			t.Errorf("Expected changes to a source's lists not to affect the map, got %v", item)
+		}
+		if host, _ := c.GetString("database.host"); host != "localhost" {
+			t.Errorf("Expected changes to a source's maps not to affect the map, got %q", host)
+		}
+	}
+	if host := defaults["database"].(map[string]interface{})["host"]; host != "changed" {
+		t.Errorf("Expected changes to loaded data not to affect the source, got %v", host)
+	}
+}
+
 func TestFileSourceLoad(t *testing.T) {
 	tmpDir := t.TempDir()
 	tmpFile := filepath.Join(tmpDir, "config.json")
@@ -320,3 +593,139 @@ func TestNewEnvSourceWithSeparator(t *testing.T) {
 		t.Error("Expected nested value")
 	}
 }
+
+func TestOptionalFileSourceReportsParseErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpFile := filepath.Join(tmpDir, "local.yaml")
+
+	if err := os.WriteFile(tmpFile, []byte("name: [broken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewOptionalFileSource(tmpFile).Load()
+	var parseError *ParseError
+	if !errors.As(err, &parseError) {
+		t.Error("Expected optional file with invalid content to fail, got:", err)
+	}
+}
+
+func TestOptionalFileSourceReportsAmbiguity(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"local.json", "local.yaml"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(`{}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := NewOptionalFileSource(filepath.Join(tmpDir, "local")).Load()
+	if !errors.Is(err, ErrAmbiguous) {
+		t.Error("Expected ambiguous optional file to fail, got:", err)
+	}
+}
+
+func TestConfigBuilderReportsFailingSource(t *testing.T) {
+	_, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{"name": "default"}).
+		AddFile("/nonexistent/file.json").
+		Build()
+
+	var sourceError *SourceError
+	if !errors.As(err, &sourceError) {
+		t.Fatal("Expected SourceError, got:", err)
+	}
+	if sourceError.Index != 1 || sourceError.Name != "file /nonexistent/file.json" {
+		t.Error("Unexpected source in error:", sourceError.Index, sourceError.Name)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("Expected underlying ErrNotFound to be preserved")
+	}
+}
+
+func TestConfigBuilderCollectErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	brokenFile := filepath.Join(tmpDir, "broken.json")
+	if err := os.WriteFile(brokenFile, []byte(`{"name": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{"name": "default"}).
+		AddFile("/nonexistent/file.json").
+		AddOptionalFile("/nonexistent/optional.json").
+		AddOptionalFile(brokenFile).
+		CollectErrors().
+		Build()
+
+	var buildError *BuildError
+	if !errors.As(err, &buildError) {
+		t.Fatal("Expected BuildError, got:", err)
+	}
+	if len(buildError.Errors) != 2 {
+		t.Fatal("Expected 2 failing sources, got:", buildError.Errors)
+	}
+	if buildError.Errors[0].Index != 1 || buildError.Errors[1].Index != 3 {
+		t.Error("Unexpected source positions:", buildError.Errors[0].Index, buildError.Errors[1].Index)
+	}
+	if buildError.Errors[1].Name != "optional file "+brokenFile {
+		t.Error("Unexpected source name:", buildError.Errors[1].Name)
+	}
+
+	var parseError *ParseError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &parseError) {
+		t.Error("Expected every underlying error to be reachable")
+	}
+}
+
+func TestConfigBuilderBuildInto(t *testing.T) {
+	type Config struct {
+		Database struct {
+			Host string `prefer:"host"`
+			Port int    `prefer:"port"`
+		} `prefer:"database"`
+		Debug bool `prefer:"debug"`
+	}
+
+	os.Setenv("BUILDINTO__DATABASE__PORT", "6543")
+	os.Setenv("BUILDINTO__DEBUG", "true")
+	defer os.Unsetenv("BUILDINTO__DATABASE__PORT")
+	defer os.Unsetenv("BUILDINTO__DEBUG")
+
+	var config Config
+	cm, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{
+			"database": map[string]interface{}{"host": "localhost", "port": 5432},
+		}).
+		AddEnv("BUILDINTO").
+		BuildInto(&config)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if config.Database.Host != "localhost" || config.Database.Port != 6543 || !config.Debug {
+		t.Error("Unexpected decoded values:", config)
+	}
+	if host, _ := cm.GetString("database.host"); host != "localhost" {
+		t.Error("Expected merged ConfigMap to be returned")
+	}
+}
+
+func TestConfigBuilderExactNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"id": 18446744073709551617}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{"limit": uint64(math.MaxUint64)}).
+		AddFile(path).
+		ExactNumbers().
+		Build()
+	checkTestError(t, err)
+
+	for key, expected := range map[string]string{"id": "18446744073709551617", "limit": "18446744073709551615"} {
+		value, _ := config.Get(key)
+		if integer, ok := value.(*big.Int); !ok || integer.String() != expected {
+			t.Errorf("Expected %s to be exactly %s, got %#v", key, expected, value)
+		}
+	}
+}
@@ -0,0 +1,647 @@
+package prefer
+
+import (
+	"encoding"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// tagName is the struct tag which is honored regardless of which serializer
+// produced the configuration.
+const tagName = "prefer"
+
+// KeyNaming derives configuration keys from struct field names for fields
+// which don't name their key in a prefer tag.
+type KeyNaming int
+
+const (
+	// KeyNamingDefault uses the field name as the key.
+	KeyNamingDefault KeyNaming = iota
+	// SnakeCase turns MaxBodySize into max_body_size.
+	SnakeCase
+	// KebabCase turns MaxBodySize into max-body-size.
+	KebabCase
+	// CamelCase turns MaxBodySize into maxBodySize.
+	CamelCase
+)
+
+// Key returns the configuration key for a struct field name.
+func (this KeyNaming) Key(fieldName string) string {
+	words := splitWords(fieldName)
+
+	switch this {
+	case SnakeCase:
+		return strings.Join(words, "_")
+	case KebabCase:
+		return strings.Join(words, "-")
+	case CamelCase:
+		for index := 1; index < len(words); index++ {
+			words[index] = strings.ToUpper(words[index][:1]) + words[index][1:]
+		}
+		return strings.Join(words, "")
+	}
+
+	return fieldName
+}
+
+// splitWords splits a Go identifier into lowercase words, keeping acronyms
+// together so that HTTPServerID becomes http, server and id.
+func splitWords(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+
+	for index := 1; index < len(runes); index++ {
+		current, previous := runes[index], runes[index-1]
+		boundary := false
+
+		switch {
+		case unicode.IsUpper(current) && (unicode.IsLower(previous) || unicode.IsDigit(previous)):
+			boundary = true
+		case unicode.IsUpper(current) && unicode.IsUpper(previous) &&
+			index+1 < len(runes) && unicode.IsLower(runes[index+1]):
+			boundary = true
+		case current == '_' || current == '-':
+			words = append(words, string(runes[start:index]))
+			start = index + 1
+			continue
+		}
+
+		if boundary && index > start {
+			words = append(words, string(runes[start:index]))
+			start = index
+		}
+	}
+
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+
+	result := words[:0]
+	for _, word := range words {
+		if word != "" {
+			result = append(result, strings.ToLower(word))
+		}
+	}
+	return result
+}
+
+// field describes how a struct field maps onto a configuration key.
+type field struct {
+	key   string
+	index []int
+}
+
+type fieldCacheKey struct {
+	t      reflect.Type
+	tag    string
+	naming KeyNaming
+}
+
+var fieldCache sync.Map
+
+// structFields returns the configuration fields of t as named by the given
+// struct tag. Fields of embedded structs without a name in that tag, or marked
+// inline, are promoted as if declared on t.
+func structFields(t reflect.Type, tag string, naming KeyNaming) []field {
+	cacheKey := fieldCacheKey{t: t, tag: tag, naming: naming}
+	if cached, ok := fieldCache.Load(cacheKey); ok {
+		return cached.([]field)
+	}
+
+	var fields []field
+	for index := 0; index < t.NumField(); index++ {
+		structField := t.Field(index)
+		value, hasTag := structField.Tag.Lookup(tag)
+		if value == "-" {
+			continue
+		}
+
+		// Options such as omitempty only affect encoding, so only inlining
+		// is relevant here.
+		name, options, _ := strings.Cut(value, ",")
+		inline := strings.Contains(","+options+",", ",inline,")
+		fieldType := structField.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		if (inline || structField.Anonymous && name == "") && fieldType.Kind() == reflect.Struct {
+			for _, promoted := range structFields(fieldType, tag, naming) {
+				promoted.index = append([]int{index}, promoted.index...)
+				fields = append(fields, promoted)
+			}
+			continue
+		}
+
+		if !structField.IsExported() {
+			continue
+		}
+
+		if !hasTag || name == "" {
+			name = naming.Key(structField.Name)
+		}
+
+		fields = append(fields, field{key: name, index: []int{index}})
+	}
+
+	fieldCache.Store(cacheKey, fields)
+	return fields
+}
+
+// usesPreferTags reports whether t, or any struct reachable from it, has a
+// field with a prefer tag.
+func usesPreferTags(t reflect.Type) bool {
+	return usesPreferTagsSeen(t, make(map[reflect.Type]bool))
+}
+
+func usesPreferTagsSeen(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	for index := 0; index < t.NumField(); index++ {
+		structField := t.Field(index)
+		if _, ok := structField.Tag.Lookup(tagName); ok {
+			return true
+		}
+		if usesPreferTagsSeen(structField.Type, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldError is a value in a configuration which could not be decoded into
+// the type of its destination.
+type FieldError struct {
+	Path  string
+	Value interface{}
+	Type  reflect.Type
+	Err   error
+}
+
+func (this *FieldError) Error() string {
+	path := this.Path
+	if path == "" {
+		path = "(root)"
+	}
+	if this.Err != nil {
+		return fmt.Sprintf("%s: cannot decode %T %v into %s: %v", path, this.Value, this.Value, this.Type, this.Err)
+	}
+	return fmt.Sprintf("%s: cannot decode %T into %s", path, this.Value, this.Type)
+}
+
+func (this *FieldError) Unwrap() error {
+	return this.Err
+}
+
+// DecodeError reports every value which could not be decoded, rather than
+// only the first.
+type DecodeError struct {
+	Errors []*FieldError
+}
+
+func (this *DecodeError) Error() string {
+	if len(this.Errors) == 1 {
+		return this.Errors[0].Error()
+	}
+	messages := make([]string, len(this.Errors))
+	for index, err := range this.Errors {
+		messages[index] = err.Error()
+	}
+	return fmt.Sprintf("%d values could not be decoded: %s", len(this.Errors), strings.Join(messages, "; "))
+}
+
+func (this *DecodeError) Unwrap() []error {
+	errs := make([]error, len(this.Errors))
+	for index, err := range this.Errors {
+		errs[index] = err
+	}
+	return errs
+}
+
+// decoder maps a generic configuration tree, as produced by decoding any
+// format into an interface{}, onto typed Go values. Failures are collected so
+// that all of them can be reported at once.
+type decoder struct {
+	naming KeyNaming
+	// weak enables conversions for values which are typically written as
+	// strings, such as comma-separated lists.
+	weak   bool
+	errors []*FieldError
+}
+
+// decodeValue decodes a single value found at path into dest, which must be a
+// non-nil pointer. A single failure is returned as a *FieldError.
+func decodeValue(path string, value interface{}, dest interface{}, weak bool) error {
+	d := &decoder{weak: weak}
+	d.decode(path, value, reflect.ValueOf(dest).Elem())
+
+	switch len(d.errors) {
+	case 0:
+		return nil
+	case 1:
+		return d.errors[0]
+	}
+	return &DecodeError{Errors: d.errors}
+}
+
+// decodeTree decodes a generic configuration tree into dest, which must be a
+// non-nil pointer. It returns a *DecodeError listing every failure.
+func decodeTree(tree interface{}, dest interface{}, naming KeyNaming, weak bool) error {
+	out := reflect.ValueOf(dest)
+	if out.Kind() != reflect.Ptr || out.IsNil() {
+		return fmt.Errorf("cannot decode into non-pointer %T", dest)
+	}
+
+	d := &decoder{naming: naming, weak: weak}
+	d.decode("", tree, out.Elem())
+	if len(d.errors) > 0 {
+		return &DecodeError{Errors: d.errors}
+	}
+	return nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return formatKeySegment(key)
+	}
+	return path + keySeparator + formatKeySegment(key)
+}
+
+func (d *decoder) fail(path string, input interface{}, out reflect.Value, err error) {
+	d.errors = append(d.errors, &FieldError{Path: path, Value: input, Type: out.Type(), Err: err})
+}
+
+func (d *decoder) decode(path string, input interface{}, out reflect.Value) {
+	if input == nil {
+		return
+	}
+
+	if out.Kind() == reflect.Ptr {
+		if out.IsNil() {
+			out.Set(reflect.New(out.Type().Elem()))
+		}
+		d.decode(path, input, out.Elem())
+		return
+	}
+
+	inputValue := reflect.ValueOf(input)
+	// Maps and lists are copied, so that decoded values don't share the
+	// tree they were decoded from
+	if out.Kind() == reflect.Interface && out.NumMethod() == 0 {
+		out.Set(reflect.ValueOf(copyTree(input)))
+		return
+	}
+
+	if handled, err := decodeNumber(input, out); handled {
+		if err != nil {
+			d.fail(path, input, out, err)
+		}
+		return
+	}
+
+	if text, ok := input.(string); ok {
+		if handled, err := decodeText(text, out); handled {
+			if err != nil {
+				d.fail(path, input, out, err)
+			}
+			return
+		}
+	}
+
+	if text, ok := input.(string); ok && out.CanAddr() {
+		if unmarshaler, ok := out.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			if err := unmarshaler.UnmarshalText([]byte(text)); err != nil {
+				d.fail(path, input, out, err)
+			}
+			return
+		}
+	}
+
+	switch out.Kind() {
+	case reflect.Struct:
+		if inputValue.Type().AssignableTo(out.Type()) {
+			out.Set(inputValue)
+			return
+		}
+		d.decodeStruct(path, input, out)
+		return
+	case reflect.Map:
+		d.decodeMap(path, input, out)
+		return
+	case reflect.Slice, reflect.Array:
+		d.decodeSlice(path, input, out)
+		return
+	}
+
+	if err := d.decodeScalar(input, out); err != nil {
+		d.fail(path, input, out, err)
+	}
+}
+
+func (d *decoder) decodeStruct(path string, input interface{}, out reflect.Value) {
+	data, ok := input.(map[string]interface{})
+	if !ok {
+		d.fail(path, input, out, nil)
+		return
+	}
+
+	for _, f := range structFields(out.Type(), tagName, d.naming) {
+		key, value, found := lookupKey(data, f.key)
+		if !found {
+			continue
+		}
+
+		target, err := fieldByIndex(out, f.index)
+		if err != nil {
+			d.fail(joinPath(path, key), value, out, err)
+			continue
+		}
+		d.decode(joinPath(path, key), value, target)
+	}
+}
+
+// lookupKey finds key in data, falling back to a case-insensitive match.
+func lookupKey(data map[string]interface{}, key string) (string, interface{}, bool) {
+	if value, ok := data[key]; ok {
+		return key, value, true
+	}
+	for candidate, value := range data {
+		if strings.EqualFold(candidate, key) {
+			return candidate, value, true
+		}
+	}
+	return "", nil, false
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but allocates nil
+// embedded struct pointers along the way.
+func fieldByIndex(out reflect.Value, index []int) (reflect.Value, error) {
+	for position, fieldIndex := range index {
+		if position > 0 && out.Kind() == reflect.Ptr {
+			if out.IsNil() {
+				if !out.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", out.Type().Elem())
+				}
+				out.Set(reflect.New(out.Type().Elem()))
+			}
+			out = out.Elem()
+		}
+		out = out.Field(fieldIndex)
+	}
+	return out, nil
+}
+
+func (d *decoder) decodeMap(path string, input interface{}, out reflect.Value) {
+	data, ok := input.(map[string]interface{})
+	if !ok || out.Type().Key().Kind() != reflect.String {
+		d.fail(path, input, out, nil)
+		return
+	}
+
+	if out.IsNil() {
+		out.Set(reflect.MakeMapWithSize(out.Type(), len(data)))
+	}
+
+	for key, value := range data {
+		mapKey := reflect.ValueOf(key).Convert(out.Type().Key())
+		element := reflect.New(out.Type().Elem()).Elem()
+		if existing := out.MapIndex(mapKey); existing.IsValid() {
+			element.Set(existing)
+		}
+		d.decode(joinPath(path, key), value, element)
+		out.SetMapIndex(mapKey, element)
+	}
+}
+
+func (d *decoder) decodeSlice(path string, input interface{}, out reflect.Value) {
+	items, ok := input.([]interface{})
+	if !ok && d.weak {
+		items, ok = weakList(input)
+	}
+	if !ok {
+		d.fail(path, input, out, nil)
+		return
+	}
+
+	if out.Kind() == reflect.Array {
+		if len(items) > out.Len() {
+			d.fail(path, input, out, fmt.Errorf("%d items do not fit in array of length %d", len(items), out.Len()))
+			return
+		}
+	} else {
+		out.Set(reflect.MakeSlice(out.Type(), len(items), len(items)))
+	}
+
+	for index, item := range items {
+		d.decode(joinPath(path, strconv.Itoa(index)), item, out.Index(index))
+	}
+}
+
+// weakList converts a scalar into a list. Strings are split on commas, as
+// lists are commonly written in environment variables, and other scalars
+// become a list of one item.
+func weakList(input interface{}) ([]interface{}, bool) {
+	switch value := input.(type) {
+	case map[string]interface{}:
+		return nil, false
+	case string:
+		if strings.TrimSpace(value) == "" {
+			return []interface{}{}, true
+		}
+		parts := strings.Split(value, ",")
+		items := make([]interface{}, len(parts))
+		for index, part := range parts {
+			items[index] = strings.TrimSpace(part)
+		}
+		return items, true
+	default:
+		return []interface{}{input}, true
+	}
+}
+
+// decodeScalar stores a scalar input in out as the package-level
+// decodeScalar does, and also formats numbers and booleans into strings, as
+// YAML decoders do for unquoted values such as "port: 8080".
+func (d *decoder) decodeScalar(input interface{}, out reflect.Value) error {
+	if out.Kind() == reflect.String {
+		switch value := input.(type) {
+		case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, *big.Int:
+			out.SetString(fmt.Sprint(value))
+			return nil
+		}
+	}
+	return decodeScalar(input, out)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decodeScalar stores a scalar input in out. Numbers may be converted between
+// types as long as no precision is lost, and strings are parsed since formats
+// such as INI and XML have no other scalar types. Durations are parsed from
+// strings such as "30s".
+func decodeScalar(input interface{}, out reflect.Value) error {
+	inputValue := reflect.ValueOf(input)
+
+	switch out.Kind() {
+	case reflect.String:
+		if inputValue.Kind() == reflect.String {
+			out.SetString(inputValue.String())
+			return nil
+		}
+	case reflect.Bool:
+		switch inputValue.Kind() {
+		case reflect.Bool:
+			out.SetBool(inputValue.Bool())
+			return nil
+		case reflect.String:
+			value, err := strconv.ParseBool(strings.TrimSpace(inputValue.String()))
+			if err != nil {
+				return err
+			}
+			out.SetBool(value)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if out.Type() == durationType && inputValue.Kind() == reflect.String {
+			duration, err := time.ParseDuration(strings.TrimSpace(inputValue.String()))
+			if err != nil {
+				return err
+			}
+			out.SetInt(int64(duration))
+			return nil
+		}
+
+		value, err := toInt64(inputValue)
+		if err != nil {
+			return err
+		}
+		if out.OverflowInt(value) {
+			return fmt.Errorf("%d overflows %s", value, out.Type())
+		}
+		out.SetInt(value)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		value, err := toUint64(inputValue)
+		if err != nil {
+			return err
+		}
+		if out.OverflowUint(value) {
+			return fmt.Errorf("%d overflows %s", value, out.Type())
+		}
+		out.SetUint(value)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		value, err := toFloat64(inputValue)
+		if err != nil {
+			return err
+		}
+		if out.OverflowFloat(value) {
+			return fmt.Errorf("%g overflows %s", value, out.Type())
+		}
+		out.SetFloat(value)
+		return nil
+	}
+
+	if inputValue.Type().ConvertibleTo(out.Type()) && inputValue.Kind() == out.Kind() {
+		out.Set(inputValue.Convert(out.Type()))
+		return nil
+	}
+	return fmt.Errorf("unsupported conversion")
+}
+
+func toInt64(value reflect.Value) (int64, error) {
+	if integer, ok := value.Interface().(*big.Int); ok {
+		if !integer.IsInt64() {
+			return 0, fmt.Errorf("%s overflows int64", integer)
+		}
+		return integer.Int64(), nil
+	}
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value.Uint() > 1<<63-1 {
+			return 0, fmt.Errorf("%d overflows int64", value.Uint())
+		}
+		return int64(value.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := value.Float()
+		if f < -(1<<63) || f >= 1<<63 || f != float64(int64(f)) {
+			return 0, fmt.Errorf("%g is not an integer", f)
+		}
+		return int64(f), nil
+	case reflect.String:
+		return strconv.ParseInt(strings.TrimSpace(value.String()), 0, 64)
+	}
+	return 0, fmt.Errorf("unsupported conversion")
+}
+
+func toUint64(value reflect.Value) (uint64, error) {
+	if integer, ok := value.Interface().(*big.Int); ok {
+		if !integer.IsUint64() {
+			return 0, fmt.Errorf("%s is not a uint64", integer)
+		}
+		return integer.Uint64(), nil
+	}
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value.Int() < 0 {
+			return 0, fmt.Errorf("%d is negative", value.Int())
+		}
+		return uint64(value.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return value.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		f := value.Float()
+		if f < 0 || f >= 1<<64 || f != float64(uint64(f)) {
+			return 0, fmt.Errorf("%g is not an unsigned integer", f)
+		}
+		return uint64(f), nil
+	case reflect.String:
+		return strconv.ParseUint(strings.TrimSpace(value.String()), 0, 64)
+	}
+	return 0, fmt.Errorf("unsupported conversion")
+}
+
+func toFloat64(value reflect.Value) (float64, error) {
+	if integer, ok := value.Interface().(*big.Int); ok {
+		return exactFloat64(new(big.Float).SetInt(integer))
+	}
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return exactFloat64(new(big.Float).SetInt64(value.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return exactFloat64(new(big.Float).SetUint64(value.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), nil
+	case reflect.String:
+		return strconv.ParseFloat(strings.TrimSpace(value.String()), 64)
+	}
+	return 0, fmt.Errorf("unsupported conversion")
+}
+
+// exactFloat64 returns integer as a float64, or an error when a float64
+// can't represent it exactly.
+func exactFloat64(integer *big.Float) (float64, error) {
+	result, accuracy := integer.Float64()
+	if accuracy != big.Exact {
+		return 0, fmt.Errorf("%s cannot be represented exactly as a float64", integer.Text('f', 0))
+	}
+	return result, nil
+}
@@ -0,0 +1,217 @@
+package prefer
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyNaming(t *testing.T) {
+	cases := []struct {
+		naming   KeyNaming
+		field    string
+		expected string
+	}{
+		{KeyNamingDefault, "MaxBodySize", "MaxBodySize"},
+		{SnakeCase, "MaxBodySize", "max_body_size"},
+		{KebabCase, "MaxBodySize", "max-body-size"},
+		{CamelCase, "MaxBodySize", "maxBodySize"},
+		{SnakeCase, "HTTPServerID", "http_server_id"},
+		{CamelCase, "HTTPServerID", "httpServerId"},
+		{SnakeCase, "TLS", "tls"},
+		{KebabCase, "Port8080Enabled", "port8080-enabled"},
+		{SnakeCase, "Already_Snake", "already_snake"},
+	}
+
+	for _, c := range cases {
+		if result := c.naming.Key(c.field); result != c.expected {
+			t.Errorf("Expected %s to become %s, got %s", c.field, c.expected, result)
+		}
+	}
+}
+
+func TestDecodeTreeWithPreferTags(t *testing.T) {
+	type Database struct {
+		Host string `prefer:"host"`
+		Port int    `prefer:"port,omitempty"`
+	}
+	type Base struct {
+		Name string `prefer:"name"`
+	}
+	type Config struct {
+		Base
+		Database *Database        `prefer:"database"`
+		Ratio    float32          `prefer:"ratio"`
+		Tags     []string         `prefer:"tags"`
+		Limits   map[string]uint8 `prefer:"limits"`
+		Ignored  string           `prefer:"-"`
+		Extra    interface{}      `prefer:"extra"`
+	}
+
+	tree := map[string]interface{}{
+		"name":     "app",
+		"database": map[string]interface{}{"host": "localhost", "port": int64(5432)},
+		"ratio":    0.5,
+		"tags":     []interface{}{"a", "b"},
+		"limits":   map[string]interface{}{"retries": "3"},
+		"Ignored":  "set",
+		"-":        "set",
+		"extra":    map[string]interface{}{"any": true},
+	}
+
+	var config Config
+	checkTestError(t, decodeTree(tree, &config, KeyNamingDefault, false))
+
+	if config.Name != "app" {
+		t.Error("Expected embedded field to be promoted, got:", config.Name)
+	}
+	if config.Database == nil || config.Database.Host != "localhost" || config.Database.Port != 5432 {
+		t.Error("Unexpected database:", config.Database)
+	}
+	if config.Ratio != 0.5 || len(config.Tags) != 2 || config.Limits["retries"] != 3 {
+		t.Error("Unexpected values:", config)
+	}
+	if config.Ignored != "" {
+		t.Error("Expected field tagged with - to be ignored")
+	}
+	if extra, ok := config.Extra.(map[string]interface{}); !ok || extra["any"] != true {
+		t.Error("Expected interface field to receive the raw value, got:", config.Extra)
+	}
+}
+
+func TestDecodeTreeWithKeyNaming(t *testing.T) {
+	type Config struct {
+		MaxBodySize int
+		ServerName  string `prefer:"server"`
+	}
+
+	tree := map[string]interface{}{"max-body-size": int64(1024), "server": "web"}
+
+	var config Config
+	checkTestError(t, decodeTree(tree, &config, KebabCase, false))
+
+	if config.MaxBodySize != 1024 || config.ServerName != "web" {
+		t.Error("Unexpected values:", config)
+	}
+}
+
+func TestDecodeTreeReportsPathOfFailures(t *testing.T) {
+	type Config struct {
+		Server struct {
+			Port uint8 `prefer:"port"`
+		} `prefer:"server"`
+		Count int `prefer:"count"`
+	}
+
+	var config Config
+	err := decodeTree(map[string]interface{}{
+		"server": map[string]interface{}{"port": int64(8080)},
+	}, &config, KeyNamingDefault, false)
+	if err == nil || !strings.HasPrefix(err.Error(), "server.port:") {
+		t.Error("Expected overflow error with key path, got:", err)
+	}
+
+	err = decodeTree(map[string]interface{}{"count": 3.5}, &config, KeyNamingDefault, false)
+	if err == nil || !strings.HasPrefix(err.Error(), "count:") {
+		t.Error("Expected lossy conversion error with key path, got:", err)
+	}
+
+	if err = decodeTree(map[string]interface{}{}, config, KeyNamingDefault, false); err == nil {
+		t.Error("Expected error when decoding into a non-pointer")
+	}
+}
+
+func TestLoadHonorsPreferTagsInEveryFormat(t *testing.T) {
+	type Config struct {
+		Name     string `prefer:"name"`
+		Port     int    `prefer:"port"`
+		Database struct {
+			Host string `prefer:"host"`
+		} `prefer:"database"`
+	}
+
+	files := map[string]string{
+		"config.json": `{"name": "app", "port": 8080, "database": {"host": "db"}}`,
+		"config.yaml": "name: app\nport: 8080\ndatabase:\n  host: db\n",
+		"config.toml": "name = \"app\"\nport = 8080\n[database]\nhost = \"db\"\n",
+		"config.ini":  "name = app\nport = 8080\n[database]\nhost = db\n",
+		"config.xml":  "<config><name>app</name><port>8080</port><database host=\"db\"/></config>",
+	}
+
+	tmpDir := t.TempDir()
+	for name, content := range files {
+		tmpFile := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var config Config
+		if _, err := Load(tmpFile, &config); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if config.Name != "app" || config.Port != 8080 || config.Database.Host != "db" {
+			t.Errorf("%s: unexpected values: %+v", name, config)
+		}
+	}
+}
+
+func TestLoadWithKeyNaming(t *testing.T) {
+	type Config struct {
+		ListenAddress string
+		MaxConns      int
+	}
+
+	loader := NewMemoryLoader("config.yaml", []byte("listen_address: ':80'\nmax_conns: 10\n"))
+
+	var config Config
+	if _, err := Load("unused", &config, WithLoader(loader), WithKeyNaming(SnakeCase)); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if config.ListenAddress != ":80" || config.MaxConns != 10 {
+		t.Error("Unexpected values:", config)
+	}
+}
+
+func TestDecodeTreeWithExactNumbers(t *testing.T) {
+	type Config struct {
+		ID     big.Int     `prefer:"id"`
+		Limit  *big.Int    `prefer:"limit"`
+		Price  json.Number `prefer:"price"`
+		Count  int64       `prefer:"count"`
+		Weight float64     `prefer:"weight"`
+	}
+
+	huge, _ := new(big.Int).SetString("18446744073709551617", 10)
+	tree := map[string]interface{}{
+		"id":     huge,
+		"limit":  "0x10",
+		"price":  json.Number("0.10000000000000000555"),
+		"count":  big.NewInt(7),
+		"weight": json.Number("1.5"),
+	}
+
+	var config Config
+	checkTestError(t, decodeTree(tree, &config, KeyNamingDefault, false))
+
+	if config.ID.Cmp(huge) != 0 {
+		t.Errorf("Expected id to be %s, got %s", huge, &config.ID)
+	}
+	if config.Limit == nil || config.Limit.Int64() != 16 {
+		t.Errorf("Expected limit to be 16, got %v", config.Limit)
+	}
+	if config.Price != "0.10000000000000000555" {
+		t.Errorf("Expected price to keep every digit, got %s", config.Price)
+	}
+	if config.Count != 7 || config.Weight != 1.5 {
+		t.Errorf("Expected exact numbers to convert to int64 and float64, got %d and %g", config.Count, config.Weight)
+	}
+
+	err := decodeTree(map[string]interface{}{"count": huge}, &config, KeyNamingDefault, false)
+	if err == nil {
+		t.Error("Expected an error decoding an overflowing *big.Int into int64")
+	}
+}
@@ -0,0 +1,374 @@
+package prefer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChangeKind describes how a value differs between two configurations.
+type ChangeKind int
+
+const (
+	// ChangeAdded is a value which only exists in the new configuration.
+	ChangeAdded ChangeKind = iota
+	// ChangeRemoved is a value which only exists in the old configuration.
+	ChangeRemoved
+	// ChangeModified is a value which exists in both with different values
+	// of the same type.
+	ChangeModified
+	// ChangeTypeChanged is a value which exists in both with different types,
+	// such as a number which became a string or a map which became a list.
+	ChangeTypeChanged
+)
+
+func (this ChangeKind) String() string {
+	switch this {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	case ChangeTypeChanged:
+		return "type changed"
+	}
+	return "ChangeKind(" + strconv.Itoa(int(this)) + ")"
+}
+
+// Change is a single difference between two configurations. Old is nil for
+// added values and New is nil for removed ones.
+type Change struct {
+	Path string
+	Kind ChangeKind
+	Old  interface{}
+	New  interface{}
+}
+
+// String renders the change as "server.port: 8080 → 9090", with "(none)" in
+// place of the missing side of added and removed values.
+func (this Change) String() string {
+	old, new := "(none)", "(none)"
+	if this.Kind != ChangeAdded {
+		old = formatChangeValue(this.Old)
+	}
+	if this.Kind != ChangeRemoved {
+		new = formatChangeValue(this.New)
+	}
+	return fmt.Sprintf("%s: %s → %s", this.Path, old, new)
+}
+
+// FormatChanges renders changes one per line, prefixed with "+" for added
+// values, "-" for removed ones and "~" for the rest, as in a unified diff.
+func FormatChanges(changes []Change) string {
+	lines := make([]string, len(changes))
+	for index, change := range changes {
+		marker := "~"
+		switch change.Kind {
+		case ChangeAdded:
+			marker = "+"
+		case ChangeRemoved:
+			marker = "-"
+		}
+		lines[index] = marker + " " + change.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatChangeValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case map[string]interface{}, []interface{}:
+		if encoded, err := json.Marshal(v); err == nil {
+			return string(encoded)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// DiffOption configures how Diff compares configurations.
+type DiffOption func(*differ)
+
+// WithListKey compares the elements of the list at path by the value of
+// their identity key rather than by position, so that reordering a list of
+// maps such as servers identified by "name" isn't reported as changes to
+// each element. Paths are written as for ConfigMap.Get, and a "*" segment
+// matches any key or index, as in "groups.*.servers".
+//
+// Elements matched by key are reported at their index in the new list, and
+// removed elements at their index in the old list.
+func WithListKey(path, key string) DiffOption {
+	return func(d *differ) {
+		segments, err := parseKeyPath(path)
+		if err != nil {
+			return
+		}
+		d.listKeys = append(d.listKeys, listKey{pattern: segments, key: key})
+	}
+}
+
+type listKey struct {
+	pattern []pathSegment
+	key     string
+}
+
+type differ struct {
+	listKeys []listKey
+	changes  []Change
+}
+
+// Diff returns every difference between old and new, in the order their
+// paths would be visited by Walk. Maps and lists which exist on only one side
+// are reported as a single change rather than a change for each value in
+// them. Numbers are compared by value, so 8080 and 8080.0 are equal.
+func Diff(old, new *ConfigMap, opts ...DiffOption) []Change {
+	d := &differ{}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	d.compare(nil, diffData(old), diffData(new))
+	for index := range d.changes {
+		d.changes[index].Old = copyTree(d.changes[index].Old)
+		d.changes[index].New = copyTree(d.changes[index].New)
+	}
+	return d.changes
+}
+
+// diffData returns the map compared for config, which is empty when config
+// is nil or a view of a missing prefix.
+func diffData(config *ConfigMap) interface{} {
+	if config != nil {
+		if data, ok := config.current().(map[string]interface{}); ok {
+			return data
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// equalTrees reports whether Diff would find no changes between a and b.
+func equalTrees(a, b interface{}) bool {
+	d := &differ{}
+	d.compare(nil, a, b)
+	return len(d.changes) == 0
+}
+
+func (d *differ) report(path []string, kind ChangeKind, old, new interface{}) {
+	d.changes = append(d.changes, Change{Path: strings.Join(path, keySeparator), Kind: kind, Old: old, New: new})
+}
+
+func (d *differ) compare(path []string, old, new interface{}) {
+	oldKind, newKind := valueKind(old), valueKind(new)
+	if oldKind != newKind {
+		d.report(path, ChangeTypeChanged, old, new)
+		return
+	}
+
+	switch oldKind {
+	case "map":
+		d.compareMaps(path, old.(map[string]interface{}), new.(map[string]interface{}))
+	case "list":
+		if key, ok := d.listKeyFor(path); ok {
+			d.compareListsByKey(path, key, old.([]interface{}), new.([]interface{}))
+		} else {
+			d.compareLists(path, old.([]interface{}), new.([]interface{}))
+		}
+	case "number":
+		if compareNumbers(old, new) != 0 {
+			d.report(path, ChangeModified, old, new)
+		}
+	default:
+		if !equalValues(old, new) {
+			d.report(path, ChangeModified, old, new)
+		}
+	}
+}
+
+func (d *differ) compareMaps(path []string, old, new map[string]interface{}) {
+	keys := make([]string, 0, len(old)+len(new))
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range new {
+		if _, ok := old[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		child := append(path[:len(path):len(path)], formatKeySegment(key))
+		oldValue, inOld := old[key]
+		newValue, inNew := new[key]
+		switch {
+		case !inNew:
+			d.report(child, ChangeRemoved, oldValue, nil)
+		case !inOld:
+			d.report(child, ChangeAdded, nil, newValue)
+		default:
+			d.compare(child, oldValue, newValue)
+		}
+	}
+}
+
+func (d *differ) compareLists(path []string, old, new []interface{}) {
+	for index := 0; index < len(old) || index < len(new); index++ {
+		child := append(path[:len(path):len(path)], strconv.Itoa(index))
+		switch {
+		case index >= len(new):
+			d.report(child, ChangeRemoved, old[index], nil)
+		case index >= len(old):
+			d.report(child, ChangeAdded, nil, new[index])
+		default:
+			d.compare(child, old[index], new[index])
+		}
+	}
+}
+
+func (d *differ) compareListsByKey(path []string, key string, old, new []interface{}) {
+	oldIndexes := make(map[string]int)
+	for index, item := range old {
+		if identity, ok := listIdentity(item, key); ok {
+			oldIndexes[identity] = index
+		}
+	}
+
+	matched := make(map[int]bool)
+	for index, item := range new {
+		child := append(path[:len(path):len(path)], strconv.Itoa(index))
+		identity, ok := listIdentity(item, key)
+		oldIndex, found := oldIndexes[identity]
+		if !ok || !found || matched[oldIndex] {
+			d.report(child, ChangeAdded, nil, item)
+			continue
+		}
+		matched[oldIndex] = true
+		d.compare(child, old[oldIndex], item)
+	}
+
+	for index, item := range old {
+		if !matched[index] {
+			d.report(append(path[:len(path):len(path)], strconv.Itoa(index)), ChangeRemoved, item, nil)
+		}
+	}
+}
+
+// listKeyFor returns the identity key configured for the list at path.
+func (d *differ) listKeyFor(path []string) (string, bool) {
+	for _, candidate := range d.listKeys {
+		if matchPathPattern(candidate.pattern, path) {
+			return candidate.key, true
+		}
+	}
+	return "", false
+}
+
+// matchPathPattern reports whether path, made of formatted keys and list
+// indexes, matches pattern, in which a bare "*" matches any one segment.
+func matchPathPattern(pattern []pathSegment, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for index, segment := range pattern {
+		if segment.kind == segmentBare && segment.key == "*" {
+			continue
+		}
+		name := formatKeySegment(segment.key)
+		if segment.kind == segmentIndex {
+			name = strconv.Itoa(segment.index)
+		}
+		if name != path[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// listIdentity returns the identity of a list element which is a map with
+// a scalar value for key.
+func listIdentity(item interface{}, key string) (string, bool) {
+	data, ok := item.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	value, ok := data[key]
+	if !ok || !isLeaf(value) {
+		return "", false
+	}
+	return valueKind(value) + ":" + fmt.Sprint(value), true
+}
+
+// valueKind groups values into the kinds which Diff considers the same type.
+func valueKind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "map"
+	case []interface{}:
+		return "list"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, *big.Int, json.Number:
+		return "number"
+	case time.Time:
+		return "time"
+	}
+	return reflect.TypeOf(value).String()
+}
+
+// compareNumbers compares two numbers of any type by value.
+func compareNumbers(a, b interface{}) int {
+	first, firstOK := bigFloat(a)
+	second, secondOK := bigFloat(b)
+	if !firstOK || !secondOK {
+		if equalValues(a, b) {
+			return 0
+		}
+		return 1
+	}
+	return first.Cmp(second)
+}
+
+func bigFloat(value interface{}) (*big.Float, bool) {
+	switch v := value.(type) {
+	case *big.Int:
+		return new(big.Float).SetInt(v), true
+	case json.Number:
+		result, ok := new(big.Float).SetString(string(v))
+		return result, ok
+	}
+
+	number := reflect.ValueOf(value)
+	switch number.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Float).SetInt64(number.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Float).SetUint64(number.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		if f := number.Float(); f == f && f-f == 0 {
+			return big.NewFloat(f), true
+		}
+	}
+	return nil, false
+}
+
+func equalValues(a, b interface{}) bool {
+	if first, ok := a.(time.Time); ok {
+		second, ok := b.(time.Time)
+		return ok && first.Equal(second)
+	}
+	return reflect.DeepEqual(a, b)
+}
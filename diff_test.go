@@ -0,0 +1,98 @@
+package prefer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := NewConfigMap(map[string]interface{}{
+		"server": map[string]interface{}{"port": 8080, "debug": true},
+		"name":   "app",
+		"limits": map[string]interface{}{"rate": 10},
+		"tags":   []interface{}{"a", "b", "c"},
+		"ratio":  1,
+	})
+	new := NewConfigMap(map[string]interface{}{
+		"server": map[string]interface{}{"port": 9090, "tls": true},
+		"name":   "app",
+		"limits": []interface{}{10},
+		"tags":   []interface{}{"a", "x"},
+		"ratio":  1.0,
+	})
+
+	expected := []Change{
+		{Path: "limits", Kind: ChangeTypeChanged, Old: map[string]interface{}{"rate": int64(10)}, New: []interface{}{int64(10)}},
+		{Path: "server.debug", Kind: ChangeRemoved, Old: true},
+		{Path: "server.port", Kind: ChangeModified, Old: int64(8080), New: int64(9090)},
+		{Path: "server.tls", Kind: ChangeAdded, New: true},
+		{Path: "tags.1", Kind: ChangeModified, Old: "b", New: "x"},
+		{Path: "tags.2", Kind: ChangeRemoved, Old: "c"},
+	}
+
+	changes := Diff(old, new)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+	if len(Diff(new, new)) != 0 {
+		t.Error("Expected no changes between a map and itself")
+	}
+	if changes := Diff(nil, new.Sub("server")); len(changes) != 2 || changes[0].Path != "port" {
+		t.Errorf("Expected paths relative to a view's prefix, got %v", changes)
+	}
+}
+
+func TestDiffWithListKey(t *testing.T) {
+	old := NewConfigMap(map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"name": "a", "port": 1},
+			map[string]interface{}{"name": "b", "port": 2},
+			map[string]interface{}{"name": "c", "port": 3},
+		},
+	})
+	new := NewConfigMap(map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"name": "c", "port": 3},
+			map[string]interface{}{"name": "a", "port": 10},
+			map[string]interface{}{"name": "d", "port": 4},
+		},
+	})
+
+	expected := []Change{
+		{Path: "servers.1.port", Kind: ChangeModified, Old: int64(1), New: int64(10)},
+		{Path: "servers.2", Kind: ChangeAdded, New: map[string]interface{}{"name": "d", "port": int64(4)}},
+		{Path: "servers.1", Kind: ChangeRemoved, Old: map[string]interface{}{"name": "b", "port": int64(2)}},
+	}
+
+	changes := Diff(old, new, WithListKey("servers", "name"))
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+	if len(Diff(old, new)) != 6 {
+		t.Errorf("Expected positional comparison without a list key, got %v", Diff(old, new))
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	changes := []Change{
+		{Path: "server.port", Kind: ChangeModified, Old: int64(8080), New: int64(9090)},
+		{Path: "server.tls", Kind: ChangeAdded, New: true},
+		{Path: "server.name", Kind: ChangeRemoved, Old: "web"},
+		{Path: "limits", Kind: ChangeTypeChanged, Old: int64(10), New: []interface{}{int64(10)}},
+	}
+
+	if changes[0].String() != "server.port: 8080 → 9090" {
+		t.Errorf("Unexpected rendering %q", changes[0].String())
+	}
+
+	expected := "~ server.port: 8080 → 9090\n" +
+		"+ server.tls: (none) → true\n" +
+		"- server.name: \"web\" → (none)\n" +
+		"~ limits: 10 → [10]"
+	if rendered := FormatChanges(changes); rendered != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, rendered)
+	}
+	if ChangeTypeChanged.String() != "type changed" {
+		t.Error("Expected change kinds to render as words")
+	}
+}
@@ -0,0 +1,467 @@
+package prefer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvOption configures an EnvSource.
+type EnvOption func(*EnvSource)
+
+// WithEnvType converts values to the types of the fields of sample, a struct
+// or a pointer to one, as they would be decoded into it. Fields are matched
+// to variables as Decode matches them to keys, ignoring case and the
+// underscores and dashes between words, so MYAPP__MAX_BODY_SIZE sets the
+// MaxBodySize field whatever its key naming. Keys are spelled as the fields
+// name them.
+//
+// Values for which sample has no field are converted to the type of the
+// value which sources before the EnvSource in a ConfigBuilder set, if any.
+func WithEnvType(sample interface{}) EnvOption {
+	return func(s *EnvSource) {
+		s.sampleType = reflect.TypeOf(sample)
+	}
+}
+
+// WithEnvListSeparator sets the separator between the elements of lists,
+// which is "," by default. Lists may always be given as JSON instead, and
+// with an empty separator, other values become lists of one element.
+func WithEnvListSeparator(separator string) EnvOption {
+	return func(s *EnvSource) {
+		s.listSeparator = separator
+	}
+}
+
+// WithEnvKeyMapper sets how each part of a variable's name after the prefix
+// becomes a key, which is strings.ToLower by default. A nil mapper keeps the
+// names as they are. Keys which match a field of the WithEnvType sample or a
+// key set by an earlier source are spelled as those are instead.
+func WithEnvKeyMapper(mapper func(string) string) EnvOption {
+	return func(s *EnvSource) {
+		if mapper == nil {
+			mapper = func(part string) string { return part }
+		}
+		s.keyMapper = mapper
+	}
+}
+
+// envKeys resolves the parts of a variable's name to keys, returning them
+// along with the type its value should be converted to, from the sample's
+// fields or the value which lower layers have for the same keys.
+func (s *EnvSource) envKeys(parts []string, lower map[string]interface{}) ([]string, reflect.Type) {
+	keys := make([]string, len(parts))
+	sample := s.sampleType
+	var existing interface{} = lower
+
+	for index, part := range parts {
+		keys[index] = s.keyMapper(part)
+		matched := false
+
+		for sample != nil && sample.Kind() == reflect.Ptr {
+			sample = sample.Elem()
+		}
+		if sample != nil {
+			switch sample.Kind() {
+			case reflect.Struct:
+				var next reflect.Type
+				for _, f := range structFields(sample, tagName, KeyNamingDefault) {
+					if envKeyMatches(part, f.key) {
+						keys[index], matched = f.key, true
+						next = sample.FieldByIndex(f.index).Type
+						break
+					}
+				}
+				sample = next
+			case reflect.Map, reflect.Slice, reflect.Array:
+				sample = sample.Elem()
+			default:
+				sample = nil
+			}
+		}
+
+		key, value, found := lowerKey(part, existing)
+		if found && !matched {
+			keys[index] = key
+		}
+		existing = value
+	}
+
+	if sample == nil || sample.Kind() == reflect.Interface {
+		return keys, lowerType(existing)
+	}
+	return keys, sample
+}
+
+// lowerKey finds the key or index which part names in existing, a value
+// from lower layers, returning it as lower layers spell it along with the
+// value there.
+func lowerKey(part string, existing interface{}) (string, interface{}, bool) {
+	switch node := existing.(type) {
+	case map[string]interface{}:
+		if value, ok := node[part]; ok {
+			return part, value, true
+		}
+		for key, value := range node {
+			if envKeyMatches(part, key) {
+				return key, value, true
+			}
+		}
+	case []interface{}:
+		if position, err := strconv.Atoi(part); err == nil && position >= 0 && position < len(node) {
+			return part, node[position], true
+		}
+	}
+	return part, nil, false
+}
+
+// envKeyMatches reports whether part of a variable's name names key.
+func envKeyMatches(part, key string) bool {
+	strip := strings.NewReplacer("_", "", "-", "")
+	return strings.EqualFold(strip.Replace(part), strip.Replace(key))
+}
+
+// lowerType returns the type which a value from a lower layer is decoded
+// as, or nil for values whose type says nothing about how to convert text.
+func lowerType(value interface{}) reflect.Type {
+	switch node := value.(type) {
+	case bool, int64, uint64, float64:
+		return reflect.TypeOf(value)
+	case []interface{}:
+		element := reflect.TypeOf((*interface{})(nil)).Elem()
+		if len(node) > 0 {
+			if t := lowerType(node[0]); t != nil {
+				element = t
+			}
+		}
+		return reflect.SliceOf(element)
+	case map[string]interface{}:
+		return reflect.TypeOf(node)
+	}
+	return nil
+}
+
+// coerceText converts the text of a variable or flag to a value which
+// decodes as t, splitting lists on separator. Text is kept as it is for types
+// which are decoded from text, such as strings, times and types implementing
+// encoding.TextUnmarshaler.
+func coerceText(text string, t reflect.Type, separator string) (interface{}, error) {
+	if t == nil {
+		return text, nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == durationType {
+		_, err := time.ParseDuration(strings.TrimSpace(text))
+		return text, err
+	}
+	if decodesFromText(t) {
+		return text, nil
+	}
+
+	trimmed := strings.TrimSpace(text)
+	switch t.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(trimmed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(trimmed, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, err := strconv.ParseUint(trimmed, 10, 64)
+		return normalizeValue(value, true), err
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(trimmed, 64)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return text, nil
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			return decodeEnvJSON(trimmed)
+		}
+		if trimmed == "" {
+			return []interface{}{}, nil
+		}
+		items := []string{text}
+		if separator != "" {
+			items = strings.Split(text, separator)
+		}
+		result := make([]interface{}, len(items))
+		for index, item := range items {
+			value, err := coerceText(strings.TrimSpace(item), t.Elem(), separator)
+			if err != nil {
+				return nil, err
+			}
+			result[index] = value
+		}
+		return result, nil
+	case reflect.Map, reflect.Struct:
+		if !strings.HasPrefix(trimmed, "{") {
+			return nil, fmt.Errorf("expected a JSON object")
+		}
+		return decodeEnvJSON(trimmed)
+	}
+	return text, nil
+}
+
+func decodeEnvJSON(text string) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(text)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected content after JSON value")
+	}
+	return normalizeValue(value, true), nil
+}
+
+// decodesFromText reports whether values of type t are decoded from text as
+// a whole, rather than field by field or item by item.
+func decodesFromText(t reflect.Type) bool {
+	return reflect.PointerTo(t).Implements(textUnmarshalerType) || t == timeType || t == urlType || t == ipNetType
+}
+
+// envTagName is the struct tag which overrides a field's part of the names
+// of the variables bound by WithEnvPrefix.
+const envTagName = "env"
+
+// envSeparator separates the parts of the names of variables bound by
+// WithEnvPrefix, as it does by default for EnvSource.
+const envSeparator = "__"
+
+// envName returns the part of a variable's name for a configuration key,
+// such as MAX_BODY_SIZE for maxBodySize or max_body_size.
+func envName(key string) string {
+	return strings.ToUpper(strings.Join(splitWords(key), "_"))
+}
+
+// applyEnv overrides the values in dest with the environment variables named
+// after its fields when the configuration has an environment prefix.
+func (this *Configuration) applyEnv(dest interface{}) error {
+	if this.envPrefix == "" || dest == nil {
+		return nil
+	}
+	out := reflect.ValueOf(dest)
+	if out.Kind() != reflect.Ptr || out.IsNil() {
+		return nil
+	}
+
+	binder := &envBinder{
+		decoder:   &decoder{naming: this.keyNaming},
+		variables: make(map[string]string),
+	}
+	for _, env := range os.Environ() {
+		name, text, ok := strings.Cut(env, "=")
+		if ok && strings.HasPrefix(name, this.envPrefix+envSeparator) {
+			binder.variables[name] = text
+		}
+	}
+	if len(binder.variables) == 0 {
+		return nil
+	}
+
+	if err := binder.bind(this.envPrefix, "", out.Elem()); err != nil {
+		return err
+	}
+	if len(binder.decoder.errors) > 0 {
+		return &DecodeError{Errors: binder.decoder.errors}
+	}
+	return nil
+}
+
+// envBinder sets the fields of a decoded value from the variables named
+// after them.
+type envBinder struct {
+	decoder   *decoder
+	variables map[string]string
+}
+
+// bind sets out, found at path, from the variable called name and those
+// named after the fields or items within it.
+func (this *envBinder) bind(name, path string, out reflect.Value) error {
+	text, set := this.variables[name]
+	if !set && !this.hasChildren(name) {
+		return nil
+	}
+
+	for out.Kind() == reflect.Ptr {
+		if out.IsNil() {
+			out.Set(reflect.New(out.Type().Elem()))
+		}
+		out = out.Elem()
+	}
+
+	if set {
+		value, err := coerceText(text, out.Type(), ",")
+		if err != nil {
+			return fmt.Errorf("environment variable %s: cannot convert %q to %s: %w", name, text, out.Type(), err)
+		}
+		this.decoder.decode(path, value, out)
+	}
+	if decodesFromText(out.Type()) {
+		return nil
+	}
+
+	switch out.Kind() {
+	case reflect.Struct:
+		for _, f := range structFields(out.Type(), tagName, this.decoder.naming) {
+			segment, tagged := out.Type().FieldByIndex(f.index).Tag.Lookup(envTagName)
+			if segment == "-" {
+				continue
+			}
+			if !tagged || segment == "" {
+				segment = envName(f.key)
+			}
+
+			child := name + envSeparator + segment
+			if _, ok := this.variables[child]; !ok && !this.hasChildren(child) {
+				continue
+			}
+			target, err := fieldByIndex(out, f.index)
+			if err != nil {
+				this.decoder.fail(joinPath(path, f.key), nil, out, err)
+				continue
+			}
+			if err := this.bind(child, joinPath(path, f.key), target); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if out.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		// Numbered variables past the end of a slice add items to it, as
+		// long as they continue its numbering without a gap
+		indexes := this.indexes(name)
+		length := out.Len()
+		if out.Kind() == reflect.Slice {
+			for indexes[length] != "" {
+				length++
+			}
+			if length > out.Len() {
+				grown := reflect.MakeSlice(out.Type(), length, length)
+				reflect.Copy(grown, out)
+				out.Set(grown)
+			}
+		}
+		var outside []int
+		for index := range indexes {
+			if index < 0 || index >= length {
+				outside = append(outside, index)
+			}
+		}
+		sort.Ints(outside)
+		for _, index := range outside {
+			err := fmt.Errorf("index %d is outside a list of length %d", index, length)
+			this.decoder.fail(joinPath(path, strconv.Itoa(index)), indexes[index], out, err)
+		}
+		for index := 0; index < out.Len(); index++ {
+			position := strconv.Itoa(index)
+			if err := this.bind(name+envSeparator+position, joinPath(path, position), out.Index(index)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		parts := this.children(name)
+		if len(parts) == 0 {
+			return nil
+		}
+		if out.Type().Key().Kind() != reflect.String {
+			err := fmt.Errorf("cannot set the keys of %s from environment variables", out.Type())
+			this.decoder.fail(path, name+envSeparator+parts[0], out, err)
+			return nil
+		}
+		if out.IsNil() {
+			out.Set(reflect.MakeMap(out.Type()))
+		}
+		for _, part := range parts {
+			key := this.mapKey(part, out)
+			item := reflect.New(out.Type().Elem()).Elem()
+			if existing := out.MapIndex(key); existing.IsValid() {
+				item.Set(existing)
+			}
+			if err := this.bind(name+envSeparator+part, joinPath(path, key.String()), item); err != nil {
+				return err
+			}
+			out.SetMapIndex(key, item)
+		}
+	}
+	return nil
+}
+
+// children returns the distinct parts which follow name in the names of the
+// variables below it, in order.
+func (this *envBinder) children(name string) []string {
+	seen := make(map[string]bool)
+	var parts []string
+	for variable := range this.variables {
+		rest, ok := strings.CutPrefix(variable, name+envSeparator)
+		if !ok {
+			continue
+		}
+		part, _, _ := strings.Cut(rest, envSeparator)
+		if !seen[part] {
+			seen[part] = true
+			parts = append(parts, part)
+		}
+	}
+	sort.Strings(parts)
+	return parts
+}
+
+// mapKey returns the key of out which part of a variable's name sets: an
+// existing key which it matches ignoring case and underscores, or otherwise
+// part in lower case, as EnvSource maps names to keys by default.
+func (this *envBinder) mapKey(part string, out reflect.Value) reflect.Value {
+	key := strings.ToLower(part)
+	if !out.MapIndex(reflect.ValueOf(key).Convert(out.Type().Key())).IsValid() {
+		existing := out.MapKeys()
+		sort.Slice(existing, func(i, j int) bool {
+			return existing[i].String() < existing[j].String()
+		})
+		for _, candidate := range existing {
+			if envKeyMatches(part, candidate.String()) {
+				return candidate
+			}
+		}
+	}
+	return reflect.ValueOf(key).Convert(out.Type().Key())
+}
+
+// hasChildren reports whether any variable is named below name.
+func (this *envBinder) hasChildren(name string) bool {
+	for variable := range this.variables {
+		if strings.HasPrefix(variable, name+envSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexes returns the indexes of the numbered variables below name, each
+// with the first variable which uses it.
+func (this *envBinder) indexes(name string) map[int]string {
+	result := make(map[int]string)
+	for variable := range this.variables {
+		rest, ok := strings.CutPrefix(variable, name+envSeparator)
+		if !ok {
+			continue
+		}
+		part, _, _ := strings.Cut(rest, envSeparator)
+		index, err := strconv.Atoi(part)
+		if err != nil {
+			continue
+		}
+		if existing, ok := result[index]; !ok || variable < existing {
+			result[index] = variable
+		}
+	}
+	return result
+}
@@ -0,0 +1,293 @@
+package prefer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+	"gopkg.in/ini.v1"
+)
+
+var (
+	// ErrNotFound is returned when no configuration exists for an identifier.
+	ErrNotFound = errors.New("configuration not found")
+	// ErrUnsupportedFormat is returned when no serializer can read a configuration.
+	ErrUnsupportedFormat = errors.New("no matching serializer")
+	// ErrAmbiguous is returned when a directory contains configurations for
+	// the same identifier in more than one format, such as both config.yaml
+	// and config.json.
+	ErrAmbiguous = errors.New("ambiguous configuration")
+
+	// ErrKeyNotFound is returned when a key is not present in a ConfigMap.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrFrozen is returned when changing a ConfigMap which has been frozen,
+	// or which is a snapshot.
+	ErrFrozen = errors.New("configuration is frozen")
+	// ErrPatchTestFailed is returned when a "test" operation of a JSON Patch
+	// finds a different value than it expects.
+	ErrPatchTestFailed = errors.New("patch test failed")
+
+	errIsDirectory = errors.New("is a directory")
+)
+
+// LocateAttempt is a single path which FileLoader.Locate considered.
+type LocateAttempt struct {
+	Directory string
+	// Extension is empty when the identifier was tried as given.
+	Extension string
+	Path      string
+	// Err explains why the path was rejected, and is nil for paths which
+	// were found.
+	Err error
+}
+
+// LocateError is returned by FileLoader.Locate, listing every path which was
+// tried. Err is ErrNotFound, ErrAmbiguous, or the error which stopped the
+// search such as a permission error.
+type LocateError struct {
+	Identifier string
+	Attempts   []LocateAttempt
+	Err        error
+}
+
+func (this *LocateError) Error() string {
+	switch {
+	case errors.Is(this.Err, ErrAmbiguous):
+		var found []string
+		for _, attempt := range this.Attempts {
+			if attempt.Err == nil {
+				found = append(found, attempt.Path)
+			}
+		}
+		return fmt.Sprintf("%v for %q: found %s", this.Err, this.Identifier, strings.Join(found, ", "))
+	case errors.Is(this.Err, ErrNotFound):
+		directories := make(map[string]bool)
+		for _, attempt := range this.Attempts {
+			directories[attempt.Directory] = true
+		}
+		return fmt.Sprintf("%v for %q: tried %d paths in %d directories", this.Err, this.Identifier, len(this.Attempts), len(directories))
+	}
+
+	if len(this.Attempts) == 0 {
+		return fmt.Sprintf("could not locate configuration for %q: %v", this.Identifier, this.Err)
+	}
+	last := this.Attempts[len(this.Attempts)-1]
+	return fmt.Sprintf("could not locate configuration for %q: %s: %v", this.Identifier, last.Path, this.Err)
+}
+
+func (this *LocateError) Unwrap() error {
+	return this.Err
+}
+
+// Rejected returns a line for each attempt describing the path and why it was
+// rejected, for logging when a configuration can't be found.
+func (this *LocateError) Rejected() []string {
+	lines := make([]string, 0, len(this.Attempts))
+	for _, attempt := range this.Attempts {
+		if attempt.Err == nil {
+			continue
+		}
+		reason := attempt.Err.Error()
+		if errors.Is(attempt.Err, fs.ErrNotExist) {
+			reason = "does not exist"
+		}
+		lines = append(lines, attempt.Path+": "+reason)
+	}
+	return lines
+}
+
+// ParseError describes a configuration which could not be deserialized, in
+// the same way regardless of which serializer failed. Line and Column are
+// 1-based, and are zero when the serializer didn't report a position.
+type ParseError struct {
+	Identifier string
+	Format     string
+	Line       int
+	Column     int
+	// Snippet contains the lines surrounding the error, with a caret under
+	// the offending column when it is known.
+	Snippet string
+	Err     error
+}
+
+func (this *ParseError) Error() string {
+	location := this.Identifier
+	if this.Line > 0 {
+		location += ":" + strconv.Itoa(this.Line)
+		if this.Column > 0 {
+			location += ":" + strconv.Itoa(this.Column)
+		}
+	}
+	return fmt.Sprintf("%s: invalid %s: %v", location, this.Format, this.Err)
+}
+
+func (this *ParseError) Unwrap() error {
+	return this.Err
+}
+
+// snippetContext is the number of lines shown before and after the error.
+const snippetContext = 2
+
+// newParseError wraps an error returned by serializer while deserializing
+// content which was loaded from identifier.
+func newParseError(identifier string, serializer Serializer, content []byte, err error) *ParseError {
+	line, column := errorPosition(serializer, err, content)
+	return &ParseError{
+		Identifier: identifier,
+		Format:     formatName(serializer),
+		Line:       line,
+		Column:     column,
+		Snippet:    sourceSnippet(content, line, column),
+		Err:        err,
+	}
+}
+
+// formatName returns the name of the format read by serializer.
+func formatName(serializer Serializer) string {
+	switch serializer.(type) {
+	case YAMLSerializer:
+		return "YAML"
+	case JSONSerializer:
+		return "JSON"
+	case TOMLSerializer:
+		return "TOML"
+	case INISerializer:
+		return "INI"
+	case XMLSerializer:
+		return "XML"
+	case CBORSerializer:
+		return "CBOR"
+	case MsgPackSerializer:
+		return "MessagePack"
+	case *JsonnetSerializer:
+		return "Jsonnet"
+	}
+	return fmt.Sprintf("%T", serializer)
+}
+
+var (
+	// YAML reports "line 3: ..." and Jsonnet reports "file:3:7-9 ..."
+	linePattern       = regexp.MustCompile(`line (\d+)(?:, column (\d+))?`)
+	filePattern       = regexp.MustCompile(`^[^\s:]*:(\d+):(\d+)`)
+	quotedLinePattern = regexp.MustCompile(`: ([^\n]+)\s*$`)
+)
+
+// errorPosition extracts the line and column at which err occurred from the
+// error types, or failing that the messages, of each serializer's library.
+func errorPosition(serializer Serializer, err error, content []byte) (int, int) {
+	var tomlError *toml.DecodeError
+	if errors.As(err, &tomlError) {
+		return tomlError.Position()
+	}
+
+	var jsonError *json5.SyntaxError
+	if errors.As(err, &jsonError) {
+		return offsetPosition(content, jsonError.Offset)
+	}
+
+	var xmlError *xml.SyntaxError
+	if errors.As(err, &xmlError) {
+		return xmlError.Line, 0
+	}
+
+	var delimiterError ini.ErrDelimiterNotFound
+	if errors.As(err, &delimiterError) {
+		return findLine(content, delimiterError.Line), 0
+	}
+
+	// Only Jsonnet's messages are known to start with a file:line:column
+	// position, and others may contain times such as 12:30:45
+	message := err.Error()
+	patterns := []*regexp.Regexp{linePattern}
+	if _, ok := serializer.(*JsonnetSerializer); ok {
+		patterns = []*regexp.Regexp{filePattern}
+	}
+	for _, pattern := range patterns {
+		if match := pattern.FindStringSubmatch(message); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			column, _ := strconv.Atoi(match[2])
+			return line, column
+		}
+	}
+
+	// INI errors such as "unclosed section: [name" quote the offending line
+	if match := quotedLinePattern.FindStringSubmatch(message); match != nil {
+		return findLine(content, match[1]), 0
+	}
+
+	return 0, 0
+}
+
+// offsetPosition converts a byte offset into a line and a column counted in
+// runes, as sourceSnippet places its caret. Offsets reported by decoders
+// point just past the offending byte.
+func offsetPosition(content []byte, offset int64) (int, int) {
+	if offset <= 0 || offset > int64(len(content)) {
+		return 0, 0
+	}
+	before := content[:offset-1]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := utf8.RuneCount(before[bytes.LastIndexByte(before, '\n')+1:]) + 1
+	return line, column
+}
+
+// findLine returns the 1-based number of the first line in content which
+// equals text once surrounding whitespace is removed, or zero if none does.
+func findLine(content []byte, text string) int {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0
+	}
+	for index, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == text {
+			return index + 1
+		}
+	}
+	return 0
+}
+
+// sourceSnippet renders the lines around line with numbers in a gutter, the
+// offending line marked, and a caret under column when it is known.
+func sourceSnippet(content []byte, line, column int) string {
+	lines := strings.Split(string(content), "\n")
+	if line <= 0 || line > len(lines) {
+		return ""
+	}
+
+	first := max(1, line-snippetContext)
+	last := min(len(lines), line+snippetContext)
+	width := len(strconv.Itoa(last))
+
+	var snippet strings.Builder
+	for number := first; number <= last; number++ {
+		marker := " "
+		if number == line {
+			marker = ">"
+		}
+		text := strings.TrimRight(lines[number-1], "\r")
+		fmt.Fprintf(&snippet, "%s %*d | %s\n", marker, width, number, text)
+
+		if number == line && column > 0 {
+			// Keep tabs so the caret lines up with the text above it
+			padding := []rune(text)
+			if column-1 < len(padding) {
+				padding = padding[:column-1]
+			}
+			for index, r := range padding {
+				if r != '\t' {
+					padding[index] = ' '
+				}
+			}
+			fmt.Fprintf(&snippet, "  %*s | %s^\n", width, "", string(padding))
+		}
+	}
+	return strings.TrimRight(snippet.String(), "\n")
+}
@@ -0,0 +1,214 @@
+package prefer
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloadReturnsParseErrorForEveryFormat(t *testing.T) {
+	cases := []struct {
+		identifier string
+		content    string
+		format     string
+		line       int
+		column     int
+	}{
+		{"config.yaml", "name: app\nport: 8080\nhost: a: b\n", "YAML", 3, 0},
+		{"config.json", "{\n  \"name\": \"app\",\n  \"port\": 80 80\n}", "JSON", 3, 14},
+		{"config.toml", "name = \"app\"\nport = = 8080\n", "TOML", 2, 8},
+		{"config.ini", "name = app\n[database\nhost = x\n", "INI", 2, 0},
+		{"config.xml", "<config>\n<name>app</name>\n<port>80</config>\n", "XML", 3, 0},
+		{"config.jsonnet", "{\n  name: \"app\",\n  port: ,\n}", "Jsonnet", 3, 9},
+	}
+
+	for _, c := range cases {
+		type Config struct {
+			Name string
+		}
+
+		var config Config
+		_, err := Load("unused", &config, WithLoader(NewMemoryLoader(c.identifier, []byte(c.content))))
+
+		var parseError *ParseError
+		if !errors.As(err, &parseError) {
+			t.Errorf("%s: expected ParseError, got: %v", c.identifier, err)
+			continue
+		}
+
+		if parseError.Identifier != c.identifier || parseError.Format != c.format {
+			t.Errorf("%s: unexpected identifier or format: %s %s", c.identifier, parseError.Identifier, parseError.Format)
+		}
+		if parseError.Line != c.line || parseError.Column != c.column {
+			t.Errorf("%s: expected %d:%d, got %d:%d (%v)", c.identifier, c.line, c.column, parseError.Line, parseError.Column, parseError.Err)
+		}
+		if parseError.Snippet == "" || parseError.Unwrap() == nil {
+			t.Errorf("%s: expected snippet and wrapped error", c.identifier)
+		}
+	}
+}
+
+func TestErrorPositionColumnsAndTimes(t *testing.T) {
+	content := []byte("{\"név\": \"é\" 80}")
+	var config map[string]interface{}
+	_, err := Load("unused", &config, WithLoader(NewMemoryLoader("config.json", content)))
+
+	var parseError *ParseError
+	if !errors.As(err, &parseError) || parseError.Line != 1 || parseError.Column != 13 {
+		t.Fatalf("Expected the column to be counted in runes, got %v", err)
+	}
+	if !strings.HasSuffix(parseError.Snippet, "\n    | "+strings.Repeat(" ", 12)+"^") {
+		t.Errorf("Expected the caret under the offending rune, got:\n%s", parseError.Snippet)
+	}
+
+	timeError := errors.New("cannot parse 12:30:45 as a duration")
+	if line, column := errorPosition(YAMLSerializer{}, timeError, content); line != 0 || column != 0 {
+		t.Errorf("Expected times in messages not to be read as positions, got %d:%d", line, column)
+	}
+}
+
+func TestParseErrorMessage(t *testing.T) {
+	err := &ParseError{Identifier: "config.toml", Format: "TOML", Line: 2, Column: 8, Err: errors.New("expected value")}
+	if err.Error() != "config.toml:2:8: invalid TOML: expected value" {
+		t.Error("Unexpected message:", err.Error())
+	}
+
+	err = &ParseError{Identifier: "config.cbor", Format: "CBOR", Err: errors.New("unexpected EOF")}
+	if err.Error() != "config.cbor: invalid CBOR: unexpected EOF" {
+		t.Error("Unexpected message without position:", err.Error())
+	}
+}
+
+func TestSourceSnippet(t *testing.T) {
+	content := []byte("one\ntwo\n\tthree = ?\nfour\nfive\nsix\n")
+
+	expected := strings.Join([]string{
+		"  1 | one",
+		"  2 | two",
+		"> 3 | \tthree = ?",
+		"    | \t       ^",
+		"  4 | four",
+		"  5 | five",
+	}, "\n")
+
+	if snippet := sourceSnippet(content, 3, 9); snippet != expected {
+		t.Errorf("Unexpected snippet:\n%s\nexpected:\n%s", snippet, expected)
+	}
+
+	if snippet := sourceSnippet(content, 0, 0); snippet != "" {
+		t.Error("Expected no snippet without a line, got:", snippet)
+	}
+}
+
+func TestStrictErrorsAreNotParseErrors(t *testing.T) {
+	type Config struct {
+		Name string `yaml:"name"`
+	}
+
+	var config Config
+	loader := NewMemoryLoader("config.yaml", []byte("nmae: app\n"))
+	_, err := Load("unused", &config, WithLoader(loader), WithStrict())
+
+	var parseError *ParseError
+	if errors.As(err, &parseError) {
+		t.Error("Expected unknown keys not to be reported as a parse error")
+	}
+}
+
+func TestLocateErrorNotFound(t *testing.T) {
+	dir := t.TempDir()
+	loader := FileLoader{identifier: filepath.Join(dir, "missing")}
+
+	_, err := loader.Locate()
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatal("Expected ErrNotFound, got:", err)
+	}
+
+	var locateError *LocateError
+	if !errors.As(err, &locateError) {
+		t.Fatal("Expected LocateError, got:", err)
+	}
+
+	tried := map[string]bool{}
+	for _, attempt := range locateError.Attempts {
+		tried[attempt.Path] = true
+		if !errors.Is(attempt.Err, fs.ErrNotExist) {
+			t.Error("Expected every attempt to be rejected as missing:", attempt)
+		}
+	}
+	for _, extension := range serializerExtensions() {
+		if !tried[filepath.Join(dir, "missing")+extension] {
+			t.Error("Expected extension to be tried in the absolute directory:", extension)
+		}
+	}
+
+	if rejected := locateError.Rejected(); len(rejected) != len(locateError.Attempts) ||
+		!strings.HasSuffix(rejected[0], ": does not exist") {
+		t.Error("Unexpected rejection descriptions:", rejected)
+	}
+}
+
+func TestLocateErrorAmbiguous(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"config.json", "config.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(`{}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := FileLoader{identifier: filepath.Join(dir, "config")}.Locate()
+	if !errors.Is(err, ErrAmbiguous) {
+		t.Fatal("Expected ErrAmbiguous, got:", err)
+	}
+	if !strings.Contains(err.Error(), "config.json") || !strings.Contains(err.Error(), "config.yaml") {
+		t.Error("Expected both candidates in message, got:", err)
+	}
+
+	// Naming the file exactly is not ambiguous
+	location, err := FileLoader{identifier: filepath.Join(dir, "config.yaml")}.Locate()
+	if err != nil || location != filepath.Join(dir, "config.yaml") {
+		t.Error("Expected exact identifier to be found, got:", location, err)
+	}
+}
+
+func TestLocateSkipsDirectories(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := FileLoader{identifier: filepath.Join(dir, "config")}.Locate()
+	if err != nil || location != filepath.Join(dir, "config.json") {
+		t.Error("Expected directory to be skipped in favor of config.json, got:", location, err)
+	}
+}
+
+func TestLocateErrorStopsOnStatErrors(t *testing.T) {
+	originalStatFunc := statFunc
+	defer func() { statFunc = originalStatFunc }()
+
+	statFunc = func(name string) (os.FileInfo, error) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrPermission}
+	}
+
+	_, err := FileLoader{identifier: "/etc/app/config"}.Locate()
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Error("Expected permission error to be reported, got:", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Error("Expected permission error not to be reported as not found")
+	}
+}
+
+func TestNewSerializerReturnsErrUnsupportedFormat(t *testing.T) {
+	_, err := NewSerializer("config.dat", []byte("data"))
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Error("Expected ErrUnsupportedFormat, got:", err)
+	}
+}
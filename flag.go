@@ -0,0 +1,275 @@
+package prefer
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// helpTagName is the struct tag which gives the usage of the flags defined
+// by NewFlagSet.
+const helpTagName = "help"
+
+// FlagSource provides configuration from the flags of a parsed flag.FlagSet.
+// Only flags which were set on the command line are used, so the defaults of
+// the others don't override the values of earlier sources.
+//
+// Flags defined by NewFlagSet set the fields they were defined for. Other
+// flags are named by key paths such as "database.host", and their values are
+// converted as EnvSource converts variables, to the types of the values of
+// earlier sources. In both cases keys are spelled as earlier sources spell
+// them when they match ignoring case, underscores and dashes, and indexes
+// such as "servers[1].port" set list items as EnvSource's numbered variables
+// do.
+type FlagSource struct {
+	flags *flag.FlagSet
+}
+
+// NewFlagSource creates a FlagSource for flags, which should have been
+// parsed before the ConfigBuilder is built.
+func NewFlagSource(flags *flag.FlagSet) *FlagSource {
+	return &FlagSource{flags: flags}
+}
+
+func (s *FlagSource) String() string {
+	return "flags"
+}
+
+func (s *FlagSource) Load() (map[string]interface{}, error) {
+	loaded, err := s.loadSource(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return loaded.data, nil
+}
+
+func (s *FlagSource) loadSource(_ []Option, lower map[string]interface{}) (*loadedSource, error) {
+	result := make(map[string]interface{})
+	origins := make(map[string]Origin)
+	var failure error
+
+	s.flags.Visit(func(f *flag.Flag) {
+		if failure != nil {
+			return
+		}
+		keys, value, err := flagEntry(f, lower)
+		if err != nil {
+			failure = fmt.Errorf("flag -%s: %w", f.Name, err)
+			return
+		}
+		setNested(result, keys, value)
+
+		path := make([]string, len(keys))
+		for index, key := range keys {
+			path[index] = formatKeySegment(key)
+		}
+		origins[strings.Join(path, keySeparator)] = Origin{Source: "flag", Name: "-" + f.Name}
+	})
+	if failure != nil {
+		return nil, failure
+	}
+
+	data, err := numberedChildrenToLists(result, lower)
+	if err != nil {
+		return nil, err
+	}
+	return &loadedSource{
+		data:    data,
+		origin:  Origin{Source: "flag"},
+		origins: origins,
+	}, nil
+}
+
+// flagEntry returns the keys which a flag that was set addresses, and its
+// value.
+func flagEntry(f *flag.Flag, lower map[string]interface{}) ([]string, interface{}, error) {
+	var parts []string
+	if generated, ok := f.Value.(*flagValue); ok {
+		parts = generated.keys
+	} else {
+		segments, err := parseKeyPath(f.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, segment := range segments {
+			switch segment.kind {
+			case segmentIndex:
+				parts = append(parts, strconv.Itoa(segment.index))
+			case segmentAppend:
+				return nil, nil, fmt.Errorf("cannot append to a list")
+			default:
+				parts = append(parts, segment.key)
+			}
+		}
+	}
+
+	keys := make([]string, len(parts))
+	var existing interface{} = lower
+	for index, part := range parts {
+		keys[index], existing, _ = lowerKey(part, existing)
+	}
+
+	if generated, ok := f.Value.(*flagValue); ok {
+		return keys, generated.value, nil
+	}
+	if getter, ok := f.Value.(flag.Getter); ok {
+		switch value := getter.Get().(type) {
+		case time.Duration:
+			return keys, value.String(), nil
+		case bool, int, int64, uint, uint64, float64:
+			return keys, normalizeValue(value, true), nil
+		}
+	}
+	value, err := coerceText(f.Value.String(), lowerType(existing), ",")
+	return keys, value, err
+}
+
+// NewFlagSet returns a flag.FlagSet with a flag for every field of sample, a
+// struct or a pointer to one, whose value can be given as text: scalars,
+// lists of them, and maps given as JSON objects. Flags are named after the
+// key paths of their fields with dashes between words, such as
+// -database.max-body-size, and take their usage from the field's help tag.
+// The default shown for each flag is the value which defaults has at its key
+// path, if any. Fields whose struct type contains itself are only given
+// flags down to the first repetition, and a field whose flag name is already
+// taken by an earlier one is skipped.
+//
+// Setting a flag doesn't change sample; give the parsed set to a FlagSource,
+// such as with ConfigBuilder.AddFlags. WithKeyNaming may be given to name
+// fields as they are named when decoding.
+func NewFlagSet(name string, handling flag.ErrorHandling, sample interface{}, defaults *ConfigMap, opts ...Option) *flag.FlagSet {
+	configuration := NewConfiguration("", opts...)
+	flags := flag.NewFlagSet(name, handling)
+
+	var data interface{}
+	if defaults != nil {
+		data = defaults.current()
+	}
+	if t := reflect.TypeOf(sample); t != nil {
+		defineFlags(flags, t, nil, data, configuration.keyNaming, make(map[reflect.Type]bool))
+	}
+	return flags
+}
+
+// defineFlags defines flags for the fields of t, found at keys, given the
+// value which the defaults have there. visiting holds the struct types
+// between the root and t, which are skipped so that recursive types end.
+func defineFlags(flags *flag.FlagSet, t reflect.Type, keys []string, defaults interface{}, naming KeyNaming, visiting map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for _, f := range structFields(t, tagName, naming) {
+		structField := t.FieldByIndex(f.index)
+		fieldKeys := append(keys[:len(keys):len(keys)], f.key)
+		_, fieldDefault, _ := lowerKey(f.key, defaults)
+
+		fieldType := structField.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct && !decodesFromText(fieldType) {
+			defineFlags(flags, fieldType, fieldKeys, fieldDefault, naming, visiting)
+			continue
+		}
+		if !settableFromText(fieldType) {
+			continue
+		}
+
+		name := flagName(fieldKeys)
+		if flags.Lookup(name) != nil {
+			continue
+		}
+		value := &flagValue{keys: fieldKeys, t: fieldType, text: flagDefault(fieldDefault)}
+		flags.Var(value, name, structField.Tag.Get(helpTagName))
+	}
+}
+
+// settableFromText reports whether values of type t can be given as the
+// text of a flag. Lists of structs, maps or other lists can't be.
+func settableFromText(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return false
+	case reflect.Slice, reflect.Array:
+		element := t.Elem()
+		for element.Kind() == reflect.Ptr {
+			element = element.Elem()
+		}
+		switch element.Kind() {
+		case reflect.Struct:
+			return decodesFromText(element)
+		case reflect.Map, reflect.Slice, reflect.Array:
+			return false
+		}
+		return settableFromText(element)
+	}
+	return true
+}
+
+// flagName returns the name of the flag for a field at keys, such as
+// database.max-body-size.
+func flagName(keys []string) string {
+	parts := make([]string, len(keys))
+	for index, key := range keys {
+		parts[index] = strings.Join(splitWords(key), "-")
+	}
+	return strings.Join(parts, ".")
+}
+
+// flagDefault formats a default value as it would be given to its flag.
+func flagDefault(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case map[string]interface{}, []interface{}:
+		if encoded, err := json.Marshal(v); err == nil {
+			return string(encoded)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// flagValue is the flag.Value of a flag defined by NewFlagSet, which
+// converts its text as EnvSource converts variables.
+type flagValue struct {
+	keys  []string
+	t     reflect.Type
+	text  string
+	value interface{}
+}
+
+func (this *flagValue) String() string {
+	return this.text
+}
+
+func (this *flagValue) Set(text string) error {
+	value, err := coerceText(text, this.t, ",")
+	if err != nil {
+		return err
+	}
+	this.text, this.value = text, value
+	return nil
+}
+
+func (this *flagValue) Get() interface{} {
+	return this.value
+}
+
+// IsBoolFlag lets flags for bools be given without a value, as in -debug.
+func (this *flagValue) IsBoolFlag() bool {
+	return this.t != nil && this.t.Kind() == reflect.Bool
+}
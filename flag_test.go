@@ -0,0 +1,174 @@
+package prefer
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type flagTestConfig struct {
+	Name     string        `help:"name of the service"`
+	Debug    bool          `help:"enable debug logging"`
+	Timeout  time.Duration `help:"request timeout"`
+	Tags     []string
+	Internal func()
+	Database struct {
+		Host        string
+		MaxBodySize int
+	}
+	Servers []struct {
+		Host string
+	}
+}
+
+func TestNewFlagSet(t *testing.T) {
+	defaults := NewConfigMap(map[string]interface{}{
+		"name":     "app",
+		"tags":     []interface{}{"a", "b"},
+		"database": map[string]interface{}{"max_body_size": 1024},
+	})
+	flags := NewFlagSet("app", flag.ContinueOnError, &flagTestConfig{}, defaults)
+
+	var names []string
+	flags.VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	expected := []string{"database.host", "database.max-body-size", "debug", "name", "tags", "timeout"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected flags %v, got %v", expected, names)
+	}
+
+	name := flags.Lookup("name")
+	if name.Usage != "name of the service" || name.DefValue != "app" {
+		t.Errorf("Expected usage and default from the help tag and defaults, got %q and %q", name.Usage, name.DefValue)
+	}
+	if size := flags.Lookup("database.max-body-size"); size.DefValue != "1024" {
+		t.Errorf("Expected defaults to match keys ignoring case and underscores, got %q", size.DefValue)
+	}
+	if tags := flags.Lookup("tags"); tags.DefValue != `["a","b"]` {
+		t.Errorf("Expected list defaults as JSON, got %q", tags.DefValue)
+	}
+
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse([]string{"-timeout", "soon"}); err == nil {
+		t.Error("Expected an error for a value which can't be converted")
+	}
+}
+
+type flagTestNode struct {
+	Name string
+	Next *flagTestNode
+}
+
+func TestNewFlagSetWithRecursiveTypesAndCollidingNames(t *testing.T) {
+	flags := NewFlagSet("app", flag.ContinueOnError, &flagTestNode{}, nil)
+	if flags.Lookup("name") == nil || flags.Lookup("next.name") != nil {
+		t.Error("Expected flags down to the first repetition of a recursive type")
+	}
+
+	type Config struct {
+		MaxSize  int `prefer:"maxSize" help:"first"`
+		MaxSize2 int `prefer:"max_size" help:"second"`
+	}
+	flags = NewFlagSet("app", flag.ContinueOnError, &Config{}, nil)
+	if size := flags.Lookup("max-size"); size == nil || size.Usage != "first" {
+		t.Errorf("Expected the first field to keep a colliding flag name, got %+v", size)
+	}
+}
+
+func TestConfigBuilderFlags(t *testing.T) {
+	builder := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{
+			"name":     "app",
+			"debug":    false,
+			"database": map[string]interface{}{"host": "localhost", "max_body_size": 1024},
+		})
+
+	flags, err := builder.FlagSet("app", flag.ContinueOnError, &flagTestConfig{}, WithKeyNaming(SnakeCase))
+	checkTestError(t, err)
+	checkTestError(t, flags.Parse([]string{"-debug", "-database.max-body-size", "2048", "-tags", "x, y", "-timeout", "5s"}))
+
+	var config flagTestConfig
+	configMap, err := builder.AddFlags(flags).BuildInto(&config, WithKeyNaming(SnakeCase))
+	checkTestError(t, err)
+
+	if config.Name != "app" || config.Database.Host != "localhost" {
+		t.Errorf("Expected flags which weren't set to leave earlier values, got %+v", config)
+	}
+	if !config.Debug || config.Database.MaxBodySize != 2048 || config.Timeout != 5*time.Second {
+		t.Errorf("Expected values from flags, got %+v", config)
+	}
+	if !reflect.DeepEqual(config.Tags, []string{"x", "y"}) {
+		t.Errorf("Expected list flags to be split on commas, got %v", config.Tags)
+	}
+	if size, _ := configMap.Get("database.max_body_size"); size != int64(2048) {
+		t.Errorf("Expected flags to spell keys as earlier sources, got %v", configMap.Data())
+	}
+	if explanation, _ := configMap.Explain("debug"); explanation.Origin.String() != "flag -debug" {
+		t.Errorf("Expected flags to be explained, got %v", explanation.Origin)
+	}
+}
+
+func TestFlagSourceWithStandardFlags(t *testing.T) {
+	flags := flag.NewFlagSet("app", flag.ContinueOnError)
+	flags.Int("server.port", 8080, "")
+	flags.String("server.host", "localhost", "")
+	flags.Duration("timeout", time.Second, "")
+	flags.String("retries", "", "")
+	checkTestError(t, flags.Parse([]string{"-server.port", "9090", "-timeout", "1m", "-retries", "3"}))
+
+	config, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{"retries": 1}).
+		AddFlags(flags).
+		Build()
+	checkTestError(t, err)
+
+	if port, _ := config.Get("server.port"); port != int64(9090) {
+		t.Errorf("Expected the port from its flag, got %v", port)
+	}
+	if config.Has("server.host") {
+		t.Error("Expected flags which weren't set to be left out")
+	}
+	if timeout, _ := config.GetDuration("timeout"); timeout != time.Minute {
+		t.Errorf("Expected durations from flags, got %v", timeout)
+	}
+	if retries, _ := config.Get("retries"); retries != int64(3) {
+		t.Errorf("Expected text to be converted to the type of earlier values, got %v", retries)
+	}
+
+	bad := flag.NewFlagSet("app", flag.ContinueOnError)
+	bad.String("retries", "", "")
+	checkTestError(t, bad.Parse([]string{"-retries", "many"}))
+	_, err = NewConfigBuilder().AddDefaults(map[string]interface{}{"retries": 1}).AddFlags(bad).Build()
+	if err == nil || !strings.Contains(err.Error(), "flag -retries") {
+		t.Errorf("Expected an error naming the flag, got %v", err)
+	}
+}
+
+func TestFlagSourceSetsListItems(t *testing.T) {
+	flags := flag.NewFlagSet("app", flag.ContinueOnError)
+	flags.String("servers[1].port", "", "")
+	checkTestError(t, flags.Parse([]string{"-servers[1].port", "9090"}))
+
+	config, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{
+			"servers": []interface{}{
+				map[string]interface{}{"host": "a", "port": 1},
+				map[string]interface{}{"host": "b", "port": 2},
+			},
+		}).
+		AddFlags(flags).
+		Build()
+	checkTestError(t, err)
+
+	expected := []interface{}{
+		map[string]interface{}{"host": "a", "port": int64(1)},
+		map[string]interface{}{"host": "b", "port": int64(9090)},
+	}
+	if servers, _ := config.GetSlice("servers"); !reflect.DeepEqual(servers, expected) {
+		t.Errorf("Expected an indexed flag to change only the item it numbers, got %v", servers)
+	}
+}
@@ -4,10 +4,18 @@ go 1.22
 
 require (
 	github.com/fsnotify/fsnotify v1.9.0
+	github.com/fxamacker/cbor/v2 v2.9.4
+	github.com/google/go-jsonnet v0.20.0
 	github.com/pelletier/go-toml/v2 v2.2.3
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	github.com/yosuke-furukawa/json5 v0.1.1
 	gopkg.in/ini.v1 v1.67.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.13.0 // indirect
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	sigs.k8s.io/yaml v1.4.0 // indirect
+)
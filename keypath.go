@@ -0,0 +1,325 @@
+package prefer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Key paths address values in a configuration tree. Segments are separated
+// by dots, and may be written as:
+//
+//	servers.0.host       bare segments, which index lists when numeric
+//	servers[0].host      bracketed indexes, which always index lists
+//	servers[-1]          negative indexes, counting from the end of a list
+//	servers[]            the position after the end of a list, for appending
+//	hosts."example.com"  quoted keys, which may contain any character
+//	hosts["example.com"] bracketed quoted keys
+//	hosts.example\.com   escaped characters in bare segments
+
+type segmentKind int
+
+const (
+	// segmentBare is a map key, or a list index when it is an integer and
+	// the value being indexed is a list.
+	segmentBare segmentKind = iota
+	// segmentQuoted is always a map key.
+	segmentQuoted
+	// segmentIndex is always a list index.
+	segmentIndex
+	// segmentAppend addresses the position after the end of a list.
+	segmentAppend
+)
+
+type pathSegment struct {
+	kind  segmentKind
+	key   string
+	index int
+}
+
+func (this pathSegment) String() string {
+	switch this.kind {
+	case segmentIndex:
+		return "[" + strconv.Itoa(this.index) + "]"
+	case segmentAppend:
+		return "[]"
+	}
+	return formatKeySegment(this.key)
+}
+
+// listIndex resolves the segment against a list of the given length,
+// reporting whether it addresses a list position at all.
+func (this pathSegment) listIndex(length int) (int, bool) {
+	var index int
+	switch this.kind {
+	case segmentIndex:
+		index = this.index
+	case segmentAppend:
+		return length, true
+	case segmentBare:
+		parsed, err := strconv.Atoi(this.key)
+		if err != nil {
+			return 0, false
+		}
+		index = parsed
+	default:
+		return 0, false
+	}
+
+	if index < 0 {
+		index += length
+	}
+	return index, true
+}
+
+// parseKeyPath splits a key path into its segments.
+func parseKeyPath(key string) ([]pathSegment, error) {
+	var segments []pathSegment
+	position := 0
+
+	for {
+		if position >= len(key) {
+			return nil, fmt.Errorf("invalid key path %q: empty segment", key)
+		}
+
+		var segment pathSegment
+		var err error
+		switch key[position] {
+		case '[':
+			segment, position, err = parseBracketSegment(key, position)
+		case '"', '\'':
+			var text string
+			text, position, err = parseQuoted(key, position)
+			segment = pathSegment{kind: segmentQuoted, key: text}
+		default:
+			segment, position, err = parseBareSegment(key, position)
+		}
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+
+		for position < len(key) && key[position] == '[' {
+			segment, position, err = parseBracketSegment(key, position)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment)
+		}
+
+		if position == len(key) {
+			return segments, nil
+		}
+		if key[position] != '.' {
+			return nil, fmt.Errorf("invalid key path %q: unexpected %q at offset %d", key, key[position], position)
+		}
+		position++
+	}
+}
+
+func parseBareSegment(key string, position int) (pathSegment, int, error) {
+	var text strings.Builder
+	for position < len(key) {
+		switch c := key[position]; c {
+		case '\\':
+			if position+1 >= len(key) {
+				return pathSegment{}, 0, fmt.Errorf("invalid key path %q: trailing escape", key)
+			}
+			text.WriteByte(key[position+1])
+			position += 2
+			continue
+		case '.', '[':
+			if text.Len() == 0 {
+				return pathSegment{}, 0, fmt.Errorf("invalid key path %q: empty segment", key)
+			}
+			return pathSegment{kind: segmentBare, key: text.String()}, position, nil
+		default:
+			text.WriteByte(c)
+		}
+		position++
+	}
+	return pathSegment{kind: segmentBare, key: text.String()}, position, nil
+}
+
+func parseBracketSegment(key string, position int) (pathSegment, int, error) {
+	position++
+	if position < len(key) && (key[position] == '"' || key[position] == '\'') {
+		text, end, err := parseQuoted(key, position)
+		if err != nil {
+			return pathSegment{}, 0, err
+		}
+		if end >= len(key) || key[end] != ']' {
+			return pathSegment{}, 0, fmt.Errorf("invalid key path %q: expected ] at offset %d", key, end)
+		}
+		return pathSegment{kind: segmentQuoted, key: text}, end + 1, nil
+	}
+
+	end := strings.IndexByte(key[position:], ']')
+	if end == -1 {
+		return pathSegment{}, 0, fmt.Errorf("invalid key path %q: unclosed [", key)
+	}
+	content := strings.TrimSpace(key[position : position+end])
+	next := position + end + 1
+
+	if content == "" {
+		return pathSegment{kind: segmentAppend}, next, nil
+	}
+	index, err := strconv.Atoi(content)
+	if err != nil {
+		return pathSegment{}, 0, fmt.Errorf("invalid key path %q: %q is not a list index", key, content)
+	}
+	return pathSegment{kind: segmentIndex, index: index}, next, nil
+}
+
+// parseQuoted reads a string quoted with the quote character at position,
+// in which backslash escapes the next character. It returns the offset after
+// the closing quote.
+func parseQuoted(key string, position int) (string, int, error) {
+	quote := key[position]
+	var text strings.Builder
+	for position++; position < len(key); position++ {
+		switch c := key[position]; {
+		case c == '\\' && position+1 < len(key):
+			position++
+			text.WriteByte(key[position])
+		case c == quote:
+			return text.String(), position + 1, nil
+		default:
+			text.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("invalid key path %q: unclosed quote", key)
+}
+
+// formatKeySegment formats a map key for use in a key path, quoting it when
+// it would otherwise be read differently.
+func formatKeySegment(key string) string {
+	if key != "" && !strings.ContainsAny(key, `.[]"'\`) {
+		return key
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(key) + `"`
+}
+
+// formatKeyPath formats segments as a key path which parses back into them.
+func formatKeyPath(segments []pathSegment) string {
+	var path strings.Builder
+	for index, segment := range segments {
+		if index > 0 && segment.kind != segmentIndex && segment.kind != segmentAppend {
+			path.WriteByte('.')
+		}
+		path.WriteString(segment.String())
+	}
+	return path.String()
+}
+
+// describePath names the value at segments in error messages.
+func describePath(segments []pathSegment) string {
+	if len(segments) == 0 {
+		return "the root"
+	}
+	return formatKeyPath(segments)
+}
+
+// lookupPath walks segments from node, returning the value found.
+func lookupPath(node interface{}, segments []pathSegment) (interface{}, bool) {
+	for _, segment := range segments {
+		switch current := node.(type) {
+		case map[string]interface{}:
+			if segment.kind == segmentIndex || segment.kind == segmentAppend {
+				return nil, false
+			}
+			value, ok := current[segment.key]
+			if !ok {
+				return nil, false
+			}
+			node = value
+		case []interface{}:
+			index, ok := segment.listIndex(len(current))
+			if !ok || index < 0 || index >= len(current) {
+				return nil, false
+			}
+			node = current[index]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// setPath stores value at segments below node, creating maps and lists as
+// needed, and returns the updated node. Maps and lists along the path are
+// copied rather than modified, so that node itself is left unchanged.
+func setPath(node interface{}, segments []pathSegment, value interface{}, walked []pathSegment) (interface{}, error) {
+	segment, rest := segments[0], segments[1:]
+	walked = append(walked, segment)
+
+	if node == nil {
+		if segment.kind == segmentIndex || segment.kind == segmentAppend {
+			node = []interface{}{}
+		} else {
+			node = make(map[string]interface{})
+		}
+	}
+
+	child := func(existing interface{}) (interface{}, error) {
+		if len(rest) == 0 {
+			return value, nil
+		}
+		return setPath(existing, rest, value, walked)
+	}
+
+	switch current := node.(type) {
+	case map[string]interface{}:
+		if segment.kind == segmentIndex || segment.kind == segmentAppend {
+			return nil, fmt.Errorf("%s is a map, not a list", describePath(walked[:len(walked)-1]))
+		}
+		updated, err := child(current[segment.key])
+		if err != nil {
+			return nil, err
+		}
+		result := copyMap(current)
+		result[segment.key] = updated
+		return result, nil
+	case []interface{}:
+		index, ok := segment.listIndex(len(current))
+		if !ok {
+			return nil, fmt.Errorf("%s is a list, not a map", describePath(walked[:len(walked)-1]))
+		}
+		if index < 0 || index > len(current) {
+			return nil, fmt.Errorf("%s is out of range for a list of length %d", formatKeyPath(walked), len(current))
+		}
+		if index == len(current) {
+			updated, err := child(nil)
+			if err != nil {
+				return nil, err
+			}
+			return append(copyList(current), updated), nil
+		}
+		updated, err := child(current[index])
+		if err != nil {
+			return nil, err
+		}
+		result := copyList(current)
+		result[index] = updated
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("%s is not a map or list", describePath(walked[:len(walked)-1]))
+}
+
+// copyMap returns a shallow copy of data.
+func copyMap(data map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(data)+1)
+	for key, value := range data {
+		result[key] = value
+	}
+	return result
+}
+
+// copyList returns a shallow copy of items, with room for one more.
+func copyList(items []interface{}) []interface{} {
+	result := make([]interface{}, len(items), len(items)+1)
+	copy(result, items)
+	return result
+}
@@ -0,0 +1,53 @@
+package prefer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyPath(t *testing.T) {
+	cases := map[string][]pathSegment{
+		"a.b":             {{kind: segmentBare, key: "a"}, {kind: segmentBare, key: "b"}},
+		"servers.0.host":  {{kind: segmentBare, key: "servers"}, {kind: segmentBare, key: "0"}, {kind: segmentBare, key: "host"}},
+		"servers[0].host": {{kind: segmentBare, key: "servers"}, {kind: segmentIndex, index: 0}, {kind: segmentBare, key: "host"}},
+		"servers[-1]":     {{kind: segmentBare, key: "servers"}, {kind: segmentIndex, index: -1}},
+		"servers[]":       {{kind: segmentBare, key: "servers"}, {kind: segmentAppend}},
+		"matrix[1][2]":    {{kind: segmentBare, key: "matrix"}, {kind: segmentIndex, index: 1}, {kind: segmentIndex, index: 2}},
+		`hosts."a.b".ip`:  {{kind: segmentBare, key: "hosts"}, {kind: segmentQuoted, key: "a.b"}, {kind: segmentBare, key: "ip"}},
+		`hosts["a.b"]`:    {{kind: segmentBare, key: "hosts"}, {kind: segmentQuoted, key: "a.b"}},
+		`hosts.'a"b'`:     {{kind: segmentBare, key: "hosts"}, {kind: segmentQuoted, key: `a"b`}},
+		`hosts.a\.b`:      {{kind: segmentBare, key: "hosts"}, {kind: segmentBare, key: "a.b"}},
+		`"q\"uote"`:       {{kind: segmentQuoted, key: `q"uote`}},
+	}
+
+	for path, expected := range cases {
+		segments, err := parseKeyPath(path)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %v", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(segments, expected) {
+			t.Errorf("Expected %s to parse as %v, got %v", path, expected, segments)
+		}
+	}
+}
+
+func TestParseKeyPathInvalid(t *testing.T) {
+	for _, path := range []string{"a..b", "a.", ".a", "a[", "a[x]", `a."b`, `a\`, `a["b"`, "a[0]b"} {
+		if _, err := parseKeyPath(path); err == nil {
+			t.Errorf("Expected an error parsing %q", path)
+		}
+	}
+}
+
+func TestFormatKeyPathRoundTrip(t *testing.T) {
+	for _, path := range []string{"a.b", "servers[0].host", `hosts."example.com".ip`, `"back\\slash"`, "list[]"} {
+		segments, err := parseKeyPath(path)
+		checkTestError(t, err)
+
+		formatted := formatKeyPath(segments)
+		if formatted != path {
+			t.Errorf("Expected %s to format as itself, got %s", path, formatted)
+		}
+	}
+}
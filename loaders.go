@@ -1,8 +1,8 @@
 package prefer
 
 import (
-	"bufio"
 	"errors"
+	"io/fs"
 	"os"
 	"path"
 	"sync"
@@ -16,6 +16,12 @@ type Loader interface {
 	WatchWithContext(channel chan bool, done <-chan struct{}) error
 }
 
+// DependencyLoader is implemented by loaders which can also watch the files that
+// a configuration depends on, such as those imported by Jsonnet.
+type DependencyLoader interface {
+	WithDependencies(paths []string) Loader
+}
+
 // Watcher interface abstracts fsnotify.Watcher for testing
 type Watcher interface {
 	Add(name string) error
@@ -76,7 +82,8 @@ func NewLoader(identifier string) (Loader, error) {
 }
 
 type FileLoader struct {
-	identifier string
+	identifier   string
+	dependencies []string
 }
 
 func checkFileExists(location string) (bool, error) {
@@ -93,41 +100,94 @@ func checkFileExists(location string) (bool, error) {
 	return true, err
 }
 
+// checkCandidate returns nil when location is a file which can be loaded, or
+// an error describing why it was rejected.
+func checkCandidate(location string) error {
+	exists, err := checkFileExists(location)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fs.ErrNotExist
+	}
+	if info, err := statFunc(location); err == nil && info.IsDir() {
+		return errIsDirectory
+	}
+	return nil
+}
+
+// Locate finds the configuration file for the identifier, trying it as given
+// and then with each supported extension in every standard path. It returns a
+// *LocateError describing every path tried when no single file is found.
 func (this FileLoader) Locate() (string, error) {
+	locateError := &LocateError{Identifier: this.identifier}
+
 	// Check if identifier is already an absolute path that exists
 	if path.IsAbs(this.identifier) {
-		if exists, err := checkFileExists(this.identifier); exists {
-			return this.identifier, err
-		}
-		// Try with extensions
-		for extension := range defaultSerializers {
-			identifierWithExtension := this.identifier + extension
-			if exists, err := checkFileExists(identifierWithExtension); exists {
-				return identifierWithExtension, err
-			}
+		location, err := locateIn("", this.identifier, locateError)
+		if location != "" || err != nil {
+			return location, err
 		}
 	}
 
-	paths := GetStandardPaths()
+	for _, directory := range GetStandardPaths() {
+		location, err := locateIn(directory, path.Join(directory, this.identifier), locateError)
+		if location != "" || err != nil {
+			return location, err
+		}
+	}
 
-	for index := range paths {
-		directory := paths[index]
-		identifierWithPath := path.Join(directory, this.identifier)
+	locateError.Err = ErrNotFound
+	return "", locateError
+}
 
-		if exists, err := checkFileExists(identifierWithPath); exists {
-			return identifierWithPath, err
+// locateIn tries base as given and with each supported extension, recording
+// every attempt. Finding more than one extension is ambiguous.
+func locateIn(directory, base string, locateError *LocateError) (string, error) {
+	attempt := func(extension string) (bool, error) {
+		location := base + extension
+		err := checkCandidate(location)
+		locateError.Attempts = append(locateError.Attempts, LocateAttempt{
+			Directory: directory,
+			Extension: extension,
+			Path:      location,
+			Err:       err,
+		})
+
+		if err == nil {
+			return true, nil
 		}
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errIsDirectory) {
+			return false, nil
+		}
+		locateError.Err = err
+		return false, locateError
+	}
 
-		for extension := range defaultSerializers {
-			identifierWithExtension := identifierWithPath + extension
+	if found, err := attempt(""); found || err != nil {
+		return base, err
+	}
 
-			if exists, err := checkFileExists(identifierWithExtension); exists {
-				return identifierWithExtension, err
-			}
+	var found []string
+	for _, extension := range serializerExtensions() {
+		ok, err := attempt(extension)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			found = append(found, base+extension)
 		}
 	}
 
-	return "", errors.New("Could not find a configuration in the given location.")
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	}
+
+	locateError.Err = ErrAmbiguous
+	return "", locateError
 }
 
 func (this FileLoader) Load() (string, []byte, error) {
@@ -139,18 +199,19 @@ func (this FileLoader) Load() (string, []byte, error) {
 
 	this.identifier = location
 
-	file, err := os.Open(location)
+	result, err := os.ReadFile(location)
 	if err != nil {
 		return "", nil, err
 	}
-	defer file.Close()
 
-	var result []byte
-	for scanner := bufio.NewScanner(file); scanner.Scan(); {
-		result = append(result, scanner.Bytes()...)
-	}
+	return location, result, nil
+}
 
-	return location, result, err
+// WithDependencies returns a copy of the loader which also watches the given
+// paths, triggering a reload when any of them change.
+func (this FileLoader) WithDependencies(paths []string) Loader {
+	this.dependencies = append([]string(nil), paths...)
+	return this
 }
 
 // WatchEvent represents a file system event during watching
@@ -183,6 +244,13 @@ func (this FileLoader) WatchWithContext(channel chan bool, done <-chan struct{})
 		return err
 	}
 
+	for _, dependency := range this.dependencies {
+		if err = watcher.Add(dependency); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
 	go func() {
 		defer watcher.Close()
 		for {
@@ -0,0 +1,270 @@
+package prefer
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MergeStrategy is how DeepMergeWith and ConfigBuilder combine a value from
+// an override with the value it overrides. The zero value is the default:
+// maps are merged recursively and other values are replaced.
+type MergeStrategy struct {
+	kind mergeKind
+	key  string
+}
+
+type mergeKind int
+
+const (
+	mergeDefault mergeKind = iota
+	mergeReplace
+	mergeAppend
+	mergePrepend
+	mergeUnion
+	mergeByKey
+)
+
+var (
+	// MergeReplace replaces the value wholesale, even when both are maps.
+	MergeReplace = MergeStrategy{kind: mergeReplace}
+	// MergeAppend adds the override's list elements after the base's.
+	MergeAppend = MergeStrategy{kind: mergeAppend}
+	// MergePrepend adds the override's list elements before the base's.
+	MergePrepend = MergeStrategy{kind: mergePrepend}
+	// MergeUnion adds the override's list elements which aren't already in
+	// the base's list after them.
+	MergeUnion = MergeStrategy{kind: mergeUnion}
+)
+
+// MergeByKey merges list elements which are maps with the same value for
+// key, such as plugins identified by "name", and adds other elements from
+// the override after the base's.
+func MergeByKey(key string) MergeStrategy {
+	return MergeStrategy{kind: mergeByKey, key: key}
+}
+
+// MergeOption configures DeepMergeWith.
+type MergeOption func(*merger)
+
+// WithMergeStrategy uses strategy for the value at path. Paths are written
+// as for ConfigMap.Get, and a "*" segment matches any key or index, as in
+// "groups.*.plugins". Strategies for lists apply only when both values are
+// lists, and otherwise the default is used. Paths which aren't valid key
+// paths never match.
+func WithMergeStrategy(path string, strategy MergeStrategy) MergeOption {
+	return func(m *merger) {
+		segments, err := parseKeyPath(path)
+		if err != nil {
+			return
+		}
+		m.strategies = append(m.strategies, pathStrategy{pattern: segments, strategy: strategy})
+	}
+}
+
+// WithMergeDirectives reads directives from the keys of override maps: a
+// key ending in "+", such as "plugins+", appends its list to the list at the
+// key without the "+", and a map containing "$patch: delete" or
+// "$patch: replace" removes the key or replaces the map instead of merging
+// it. ConfigBuilder reads directives from files.
+func WithMergeDirectives() MergeOption {
+	return func(m *merger) {
+		m.directives = true
+	}
+}
+
+// withListIndexes merges override maps whose keys are numbers into the
+// items of the lists they override, as the numbered maps of EnvSource and
+// FlagSource are, rather than replacing the lists.
+func withListIndexes() MergeOption {
+	return func(m *merger) {
+		m.indexes = true
+	}
+}
+
+type pathStrategy struct {
+	pattern  []pathSegment
+	strategy MergeStrategy
+}
+
+type merger struct {
+	strategies []pathStrategy
+	directives bool
+	indexes    bool
+}
+
+// DeepMergeWith merges override into base as DeepMerge does, using the
+// strategies given for particular paths.
+func DeepMergeWith(base, override map[string]interface{}, opts ...MergeOption) map[string]interface{} {
+	m := &merger{}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m.mergeMaps(nil, base, override)
+}
+
+// patchDirective is the key in a map which says how it is merged.
+const patchDirective = "$patch"
+
+func (m *merger) mergeMaps(path []string, base, override map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(base)+len(override))
+	for key, value := range base {
+		result[key] = value
+	}
+
+	// Keys such as "plugins+" are applied after "plugins", so that a file
+	// can both set and extend a list.
+	keys := make([]string, 0, len(override))
+	for key := range override {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		first, second := m.isAppendKey(keys[i]), m.isAppendKey(keys[j])
+		if first != second {
+			return second
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		value := override[key]
+		if m.directives && key == patchDirective {
+			continue
+		}
+
+		name := key
+		if m.isAppendKey(key) {
+			name = strings.TrimSuffix(key, "+")
+		}
+		child := append(path[:len(path):len(path)], formatKeySegment(name))
+
+		if value == nil {
+			delete(result, name)
+			continue
+		}
+
+		strategy := m.strategyFor(child)
+		if name != key {
+			strategy = MergeAppend
+		}
+		if nested, ok := value.(map[string]interface{}); ok && m.directives {
+			switch nested[patchDirective] {
+			case "delete":
+				delete(result, name)
+				continue
+			case "replace":
+				strategy = MergeReplace
+			}
+		}
+
+		existing, exists := result[name]
+		result[name] = m.mergeValue(child, existing, exists, value, strategy)
+	}
+
+	return result
+}
+
+func (m *merger) isAppendKey(key string) bool {
+	return m.directives && len(key) > 1 && strings.HasSuffix(key, "+")
+}
+
+// strategyFor returns the strategy configured for the value at path.
+func (m *merger) strategyFor(path []string) MergeStrategy {
+	for _, candidate := range m.strategies {
+		if matchPathPattern(candidate.pattern, path) {
+			return candidate.strategy
+		}
+	}
+	return MergeStrategy{}
+}
+
+func (m *merger) mergeValue(path []string, base interface{}, exists bool, override interface{}, strategy MergeStrategy) interface{} {
+	baseList, baseIsList := base.([]interface{})
+	overrideList, overrideIsList := override.([]interface{})
+	if exists && baseIsList && overrideIsList {
+		switch strategy.kind {
+		case mergeAppend:
+			return append(append(make([]interface{}, 0, len(baseList)+len(overrideList)), baseList...), overrideList...)
+		case mergePrepend:
+			return append(append(make([]interface{}, 0, len(baseList)+len(overrideList)), overrideList...), baseList...)
+		case mergeUnion:
+			return m.union(baseList, overrideList)
+		case mergeByKey:
+			return m.mergeByKey(path, strategy.key, baseList, overrideList)
+		}
+	}
+
+	overrideMap, overrideIsMap := override.(map[string]interface{})
+	if !overrideIsMap {
+		return override
+	}
+	if m.indexes && exists && baseIsList && strategy.kind != mergeReplace {
+		if indexes, err := listIndexes(overrideMap, len(baseList)); err == nil && indexes != nil {
+			return m.mergeIndexes(path, baseList, overrideMap, indexes)
+		}
+	}
+	baseMap, _ := base.(map[string]interface{})
+	if strategy.kind == mergeReplace {
+		baseMap = nil
+	}
+	return m.mergeMaps(path, baseMap, overrideMap)
+}
+
+// mergeIndexes merges the values of override into the items of base which
+// their keys number, adding those past its end.
+func (m *merger) mergeIndexes(path []string, base []interface{}, override map[string]interface{}, indexes []int) []interface{} {
+	result := append(make([]interface{}, 0, len(base)+len(indexes)), base...)
+	for _, index := range indexes {
+		child := append(path[:len(path):len(path)], strconv.Itoa(index))
+		value := override[strconv.Itoa(index)]
+		if index < len(result) {
+			result[index] = m.mergeValue(child, result[index], true, value, m.strategyFor(child))
+		} else {
+			result = append(result, m.mergeValue(child, nil, false, value, m.strategyFor(child)))
+		}
+	}
+	return result
+}
+
+func (m *merger) union(base, override []interface{}) []interface{} {
+	result := append(make([]interface{}, 0, len(base)+len(override)), base...)
+	for _, item := range override {
+		found := false
+		for _, existing := range result {
+			if equalTrees(existing, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func (m *merger) mergeByKey(path []string, key string, base, override []interface{}) []interface{} {
+	result := append(make([]interface{}, 0, len(base)+len(override)), base...)
+	indexes := make(map[string]int)
+	for index, item := range base {
+		if identity, ok := listIdentity(item, key); ok {
+			indexes[identity] = index
+		}
+	}
+
+	for _, item := range override {
+		identity, ok := listIdentity(item, key)
+		index, found := indexes[identity]
+		if !ok || !found {
+			if ok {
+				indexes[identity] = len(result)
+			}
+			child := append(path[:len(path):len(path)], strconv.Itoa(len(result)))
+			result = append(result, m.mergeValue(child, nil, false, item, m.strategyFor(child)))
+			continue
+		}
+		child := append(path[:len(path):len(path)], strconv.Itoa(index))
+		result[index] = m.mergeValue(child, result[index], true, item, m.strategyFor(child))
+	}
+	return result
+}
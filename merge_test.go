@@ -0,0 +1,167 @@
+package prefer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDeepMergeWithStrategies(t *testing.T) {
+	base := map[string]interface{}{
+		"plugins":  []interface{}{"a", "b"},
+		"prepend":  []interface{}{"b"},
+		"union":    []interface{}{"a", "b"},
+		"replaced": map[string]interface{}{"a": 1, "b": 2},
+		"servers": []interface{}{
+			map[string]interface{}{"name": "web", "port": 80, "tags": []interface{}{"x"}},
+			map[string]interface{}{"name": "db", "port": 5432},
+		},
+		"groups": map[string]interface{}{
+			"admin": map[string]interface{}{"members": []interface{}{"alice"}},
+		},
+		"default": []interface{}{"a"},
+	}
+	override := map[string]interface{}{
+		"plugins":  []interface{}{"c"},
+		"prepend":  []interface{}{"a"},
+		"union":    []interface{}{"b", "c"},
+		"replaced": map[string]interface{}{"c": 3},
+		"servers": []interface{}{
+			map[string]interface{}{"name": "web", "port": 8080, "tags": []interface{}{"y"}},
+			map[string]interface{}{"name": "cache", "port": 6379},
+		},
+		"groups": map[string]interface{}{
+			"admin": map[string]interface{}{"members": []interface{}{"bob"}},
+		},
+		"default": []interface{}{"b"},
+	}
+
+	result := DeepMergeWith(base, override,
+		WithMergeStrategy("plugins", MergeAppend),
+		WithMergeStrategy("prepend", MergePrepend),
+		WithMergeStrategy("union", MergeUnion),
+		WithMergeStrategy("replaced", MergeReplace),
+		WithMergeStrategy("servers", MergeByKey("name")),
+		WithMergeStrategy("servers.*.tags", MergeAppend),
+		WithMergeStrategy("groups.*.members", MergeAppend),
+	)
+
+	expected := map[string]interface{}{
+		"plugins":  []interface{}{"a", "b", "c"},
+		"prepend":  []interface{}{"a", "b"},
+		"union":    []interface{}{"a", "b", "c"},
+		"replaced": map[string]interface{}{"c": 3},
+		"servers": []interface{}{
+			map[string]interface{}{"name": "web", "port": 8080, "tags": []interface{}{"x", "y"}},
+			map[string]interface{}{"name": "db", "port": 5432},
+			map[string]interface{}{"name": "cache", "port": 6379},
+		},
+		"groups": map[string]interface{}{
+			"admin": map[string]interface{}{"members": []interface{}{"alice", "bob"}},
+		},
+		"default": []interface{}{"b"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+	if len(base["plugins"].([]interface{})) != 2 {
+		t.Error("Expected base to be unchanged")
+	}
+}
+
+func TestDeepMergeDirectives(t *testing.T) {
+	base := map[string]interface{}{
+		"plugins":  []interface{}{"a", "b"},
+		"database": map[string]interface{}{"host": "localhost", "port": 5432},
+		"cache":    map[string]interface{}{"host": "redis"},
+	}
+	override := map[string]interface{}{
+		"plugins+": []interface{}{"c"},
+		"database": map[string]interface{}{"$patch": "replace", "url": "postgres://db"},
+		"cache":    map[string]interface{}{"$patch": "delete"},
+		"extra":    map[string]interface{}{"$patch": "merge", "list+": []interface{}{1}},
+	}
+
+	expected := map[string]interface{}{
+		"plugins":  []interface{}{"a", "b", "c"},
+		"database": map[string]interface{}{"url": "postgres://db"},
+		"extra":    map[string]interface{}{"list": []interface{}{1}},
+	}
+	if result := DeepMergeWith(base, override, WithMergeDirectives()); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+
+	both := map[string]interface{}{"tags": []interface{}{"x"}, "tags+": []interface{}{"y"}}
+	if result := DeepMergeWith(nil, both, WithMergeDirectives()); !reflect.DeepEqual(result["tags"], []interface{}{"x", "y"}) {
+		t.Errorf("Expected appends to follow the key they extend, got %v", result)
+	}
+}
+
+func TestDeepMergeTreatsKeysLiterally(t *testing.T) {
+	base := map[string]interface{}{"languages": map[string]interface{}{"c": 1}}
+	override := map[string]interface{}{
+		"languages": map[string]interface{}{"c++": []interface{}{"g++"}},
+		"patch":     map[string]interface{}{"$patch": "delete"},
+	}
+
+	expected := map[string]interface{}{
+		"languages": map[string]interface{}{"c": 1, "c++": []interface{}{"g++"}},
+		"patch":     map[string]interface{}{"$patch": "delete"},
+	}
+	if result := DeepMerge(base, override); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected keys to be merged literally, got %v", result)
+	}
+}
+
+func TestConfigBuilderReadsDirectivesOnlyFromFiles(t *testing.T) {
+	config, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{"tags": []interface{}{"a"}}).
+		AddSource(NewMemorySource(map[string]interface{}{"tags+": []interface{}{"b"}})).
+		Build()
+	checkTestError(t, err)
+
+	if tags, _ := config.GetSlice("tags"); len(tags) != 1 || !config.Has(`"tags+"`) {
+		t.Errorf("Expected directives to be ignored outside files, got %v", config.Data())
+	}
+}
+
+func TestConfigMapApplyMergePatchIgnoresDirectives(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{"plugins": []interface{}{"a"}})
+	checkTestError(t, config.ApplyMergePatch(map[string]interface{}{"plugins+": []interface{}{"b"}}))
+
+	if plugins, _ := config.GetSlice("plugins"); len(plugins) != 1 || !config.Has(`"plugins+"`) {
+		t.Error("Expected merge patches to treat keys literally")
+	}
+}
+
+func TestConfigBuilderMergeStrategy(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpFile := filepath.Join(tmpDir, "override.yaml")
+
+	content := "plugins: [c]\nhooks+: [post]\nlogging:\n  $patch: delete\n"
+	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{
+			"plugins": []interface{}{"a", "b"},
+			"hooks":   []interface{}{"pre"},
+			"logging": map[string]interface{}{"level": "debug"},
+		}).
+		AddFile(tmpFile).
+		MergeStrategy("plugins", MergeUnion).
+		Build()
+	checkTestError(t, err)
+
+	if plugins, _ := config.GetSlice("plugins"); !reflect.DeepEqual(plugins, []interface{}{"a", "b", "c"}) {
+		t.Errorf("Expected plugins to be merged as a union, got %v", plugins)
+	}
+	if hooks, _ := config.GetSlice("hooks"); !reflect.DeepEqual(hooks, []interface{}{"pre", "post"}) {
+		t.Errorf("Expected hooks+ to append, got %v", hooks)
+	}
+	if config.Has("logging") {
+		t.Error("Expected $patch: delete to remove logging")
+	}
+}
@@ -0,0 +1,153 @@
+package prefer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
+// Generic configuration trees are normalized into the same types regardless
+// of which serializer produced them:
+//
+//	maps     map[string]interface{}
+//	lists    []interface{}
+//	integers int64, or float64 when they don't fit, except that unsigned
+//	         integers too large for int64 are kept as uint64
+//	floats   float64
+//	times    time.Time, with local dates and times taken to be UTC
+//
+// Strings, booleans and nil are kept as they are, and TOML local times of day
+// become strings since they have no date.
+//
+// When numbers are exact, integers which don't fit in int64 are *big.Int
+// rather than float64, and non-integers which were decoded from their text,
+// as JSON numbers are, are json.Number so that no digits are lost.
+
+// normalizeGeneric normalizes the generic tree decoded into obj. Typed
+// destinations such as structs are left untouched.
+func normalizeGeneric(obj interface{}, exact bool) {
+	switch target := obj.(type) {
+	case *map[string]interface{}:
+		normalizeMap(*target, exact)
+	case *interface{}:
+		*target = normalizeValue(*target, exact)
+	}
+}
+
+// normalizeMap normalizes the values of data in place, and returns it.
+func normalizeMap(data map[string]interface{}, exact bool) map[string]interface{} {
+	for key, value := range data {
+		data[key] = normalizeValue(value, exact)
+	}
+	return data
+}
+
+func normalizeValue(value interface{}, exact bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return normalizeMap(v, exact)
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = normalizeValue(item, exact)
+		}
+		return result
+	case []interface{}:
+		for index, item := range v {
+			v[index] = normalizeValue(item, exact)
+		}
+		return v
+	case []map[string]interface{}:
+		result := make([]interface{}, len(v))
+		for index, item := range v {
+			result[index] = normalizeMap(item, exact)
+		}
+		return result
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return normalizeUint(uint64(v), exact)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return normalizeUint(v, exact)
+	case float32:
+		return float64(v)
+	case big.Int:
+		return normalizeBigInt(&v, exact)
+	case *big.Int:
+		return normalizeBigInt(v, exact)
+	case json5.Number:
+		return normalizeNumber(string(v), exact)
+	case json.Number:
+		return normalizeNumber(string(v), exact)
+	case toml.LocalDate:
+		return v.AsTime(time.UTC)
+	case toml.LocalDateTime:
+		return v.AsTime(time.UTC)
+	case toml.LocalTime:
+		return v.String()
+	default:
+		return value
+	}
+}
+
+// normalizeUint converts to int64, or when the value is too large to
+// *big.Int if numbers are exact. Otherwise it is kept as a uint64, rather
+// than losing precision as a float64.
+func normalizeUint(value uint64, exact bool) interface{} {
+	if value <= math.MaxInt64 {
+		return int64(value)
+	}
+	if exact {
+		return new(big.Int).SetUint64(value)
+	}
+	return value
+}
+
+func normalizeBigInt(value *big.Int, exact bool) interface{} {
+	if value.IsInt64() {
+		return value.Int64()
+	}
+	if exact {
+		return value
+	}
+	result, _ := new(big.Float).SetInt(value).Float64()
+	return result
+}
+
+// normalizeNumber converts the text of a decoded number to int64 when it is
+// an integer which fits. Otherwise exact numbers become *big.Int or
+// json.Number, and others float64.
+func normalizeNumber(text string, exact bool) interface{} {
+	if integer, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return integer
+	}
+	if exact {
+		if integer, ok := new(big.Int).SetString(text, 10); ok {
+			return integer
+		}
+		return json.Number(text)
+	}
+	result, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return text
+	}
+	return result
+}
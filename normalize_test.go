@@ -0,0 +1,219 @@
+package prefer
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSerializersProduceCanonicalTrees(t *testing.T) {
+	expected := map[string]interface{}{
+		"name":    "app",
+		"port":    int64(8080),
+		"ratio":   0.5,
+		"enabled": true,
+		"tags":    []interface{}{"a", "b"},
+		"database": map[string]interface{}{
+			"replicas": []interface{}{int64(1), int64(2)},
+		},
+	}
+
+	documents := map[string]string{
+		"config.json":    `{"name": "app", "port": 8080, "ratio": 0.5, "enabled": true, "tags": ["a", "b"], "database": {"replicas": [1, 2]}}`,
+		"config.yaml":    "name: app\nport: 8080\nratio: 0.5\nenabled: true\ntags: [a, b]\ndatabase:\n  replicas: [1, 2]\n",
+		"config.toml":    "name = \"app\"\nport = 8080\nratio = 0.5\nenabled = true\ntags = [\"a\", \"b\"]\n[database]\nreplicas = [1, 2]\n",
+		"config.jsonnet": `{name: "app", port: 8000 + 80, ratio: 1 / 2, enabled: true, tags: ["a", "b"], database: {replicas: [1, 2]}}`,
+	}
+
+	for identifier, document := range documents {
+		serializer, err := NewSerializer(identifier, []byte(document))
+		checkTestError(t, err)
+
+		result := map[string]interface{}{}
+		checkTestError(t, serializer.Deserialize([]byte(document), &result))
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("%s: expected %#v, got %#v", identifier, expected, result)
+		}
+
+		var tree interface{}
+		checkTestError(t, serializer.Deserialize([]byte(document), &tree))
+		if !reflect.DeepEqual(tree, expected) {
+			t.Errorf("%s: expected %#v when decoding into interface{}, got %#v", identifier, expected, tree)
+		}
+	}
+
+	input := map[string]interface{}{
+		"name":    "app",
+		"port":    uint16(8080),
+		"ratio":   float32(0.5),
+		"enabled": true,
+		"tags":    []string{"a", "b"},
+		"database": map[interface{}]interface{}{
+			"replicas": []int{1, 2},
+		},
+	}
+	for _, serializer := range []Serializer{CBORSerializer{}, MsgPackSerializer{}} {
+		serialized, err := serializer.Serialize(input)
+		checkTestError(t, err)
+
+		result := map[string]interface{}{}
+		checkTestError(t, serializer.Deserialize(serialized, &result))
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("%T: expected %#v, got %#v", serializer, expected, result)
+		}
+	}
+}
+
+func TestTextSerializersProduceStringKeyedMaps(t *testing.T) {
+	documents := map[string]string{
+		"config.xml": "<config><database><host>localhost</host></database></config>",
+		"config.ini": "[database]\nhost = localhost\n",
+	}
+
+	for identifier, document := range documents {
+		serializer, err := NewSerializer(identifier, []byte(document))
+		checkTestError(t, err)
+
+		var tree interface{}
+		checkTestError(t, serializer.Deserialize([]byte(document), &tree))
+
+		host, ok := NewConfigMap(tree.(map[string]interface{})).GetString("database.host")
+		if !ok || host != "localhost" {
+			t.Errorf("%s: expected database.host to be localhost, got %#v", identifier, tree)
+		}
+	}
+}
+
+func TestJSONKeepsIntegerPrecision(t *testing.T) {
+	result := map[string]interface{}{}
+	checkTestError(t, JSONSerializer{}.Deserialize([]byte(`{"id": 9007199254740993, "float": 1.0}`), &result))
+
+	if result["id"] != int64(9007199254740993) {
+		t.Errorf("Expected id to keep its precision as int64, got %T(%v)", result["id"], result["id"])
+	}
+	if result["float"] != 1.0 {
+		t.Errorf("Expected 1.0 to remain a float64, got %T(%v)", result["float"], result["float"])
+	}
+}
+
+func TestJSONReportsTrailingContent(t *testing.T) {
+	result := map[string]interface{}{}
+	if err := (JSONSerializer{}).Deserialize([]byte(`{"a": 1} x`), &result); err == nil {
+		t.Error("Expected an error for content after the document")
+	}
+}
+
+func TestTOMLDatesBecomeTimes(t *testing.T) {
+	document := "local_date = 2024-01-02\nlocal_datetime = 2024-01-02T10:30:00\noffset = 2024-01-02T10:30:00+02:00\nlocal_time = 10:30:00\n"
+
+	result := map[string]interface{}{}
+	checkTestError(t, TOMLSerializer{}.Deserialize([]byte(document), &result))
+
+	expected := map[string]interface{}{
+		"local_date":     time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		"local_datetime": time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC),
+		"local_time":     "10:30:00",
+	}
+	for key, value := range expected {
+		if !reflect.DeepEqual(result[key], value) {
+			t.Errorf("Expected %s to be %#v, got %#v", key, value, result[key])
+		}
+	}
+
+	offset, ok := result["offset"].(time.Time)
+	if !ok || !offset.Equal(time.Date(2024, 1, 2, 8, 30, 0, 0, time.UTC)) {
+		t.Errorf("Expected offset to be a time.Time, got %#v", result["offset"])
+	}
+}
+
+func TestYAMLTimestampsBecomeTimes(t *testing.T) {
+	result := map[string]interface{}{}
+	checkTestError(t, YAMLSerializer{}.Deserialize([]byte("released: 2024-01-02\n"), &result))
+
+	if result["released"] != time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("Expected released to be a time.Time, got %#v", result["released"])
+	}
+}
+
+func TestBuilderNormalizesSources(t *testing.T) {
+	config, err := NewConfigBuilder().
+		AddSource(NewMemorySource(map[string]interface{}{
+			"database": map[string]interface{}{"host": "localhost"},
+		})).
+		AddSource(NewMemorySource(map[string]interface{}{
+			"database": map[interface{}]interface{}{"port": 5432},
+		})).
+		Build()
+	checkTestError(t, err)
+
+	expected := map[string]interface{}{
+		"database": map[string]interface{}{
+			"host": "localhost",
+			"port": int64(5432),
+		},
+	}
+	if !reflect.DeepEqual(config.Data(), expected) {
+		t.Errorf("Expected maps of every type to be merged, got %#v", config.Data())
+	}
+}
+
+func TestNormalizeValue(t *testing.T) {
+	cases := []struct {
+		input    interface{}
+		expected interface{}
+	}{
+		{int(1), int64(1)},
+		{uint32(2), int64(2)},
+		{uint64(1 << 63), uint64(1 << 63)},
+		{float32(0.25), 0.25},
+		{[]map[string]interface{}{{"a": 1}}, []interface{}{map[string]interface{}{"a": int64(1)}}},
+		{map[interface{}]interface{}{1: "one"}, map[string]interface{}{"1": "one"}},
+		{"text", "text"},
+		{nil, nil},
+	}
+
+	for _, c := range cases {
+		if result := normalizeValue(c.input, false); !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("Expected %#v to normalize to %#v, got %#v", c.input, c.expected, result)
+		}
+	}
+}
+
+func TestExactNumbers(t *testing.T) {
+	document := `{"id": 18446744073709551617, "small": 12, "price": 0.10000000000000000555}`
+
+	result := map[string]interface{}{}
+	checkTestError(t, JSONSerializer{ExactNumbers: true}.Deserialize([]byte(document), &result))
+
+	id, ok := result["id"].(*big.Int)
+	if !ok || id.String() != "18446744073709551617" {
+		t.Errorf("Expected id to be an exact *big.Int, got %#v", result["id"])
+	}
+	if result["small"] != int64(12) {
+		t.Errorf("Expected small to remain int64, got %#v", result["small"])
+	}
+	if result["price"] != json.Number("0.10000000000000000555") {
+		t.Errorf("Expected price to keep every digit, got %#v", result["price"])
+	}
+
+	input := map[string]interface{}{"id": uint64(math.MaxUint64)}
+	for _, serializer := range []Serializer{CBORSerializer{ExactNumbers: true}, MsgPackSerializer{ExactNumbers: true}} {
+		serialized, err := serializer.Serialize(input)
+		checkTestError(t, err)
+
+		result := map[string]interface{}{}
+		checkTestError(t, serializer.Deserialize(serialized, &result))
+		if id, ok := result["id"].(*big.Int); !ok || !id.IsUint64() || id.Uint64() != math.MaxUint64 {
+			t.Errorf("%T: expected id to be an exact *big.Int, got %#v", serializer, result["id"])
+		}
+	}
+
+	result = map[string]interface{}{}
+	checkTestError(t, YAMLSerializer{ExactNumbers: true}.Deserialize([]byte("id: 18446744073709551615\n"), &result))
+	if id, ok := result["id"].(*big.Int); !ok || id.String() != "18446744073709551615" {
+		t.Errorf("Expected YAML id to be an exact *big.Int, got %#v", result["id"])
+	}
+}
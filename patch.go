@@ -0,0 +1,480 @@
+package prefer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation is a single operation of an RFC 6902 JSON Patch. Op is one
+// of "add", "remove", "replace", "move", "copy" or "test", and Path and From
+// are JSON Pointers such as "/servers/0/host".
+type PatchOperation struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// Patch is an RFC 6902 JSON Patch, which ConfigMap.ApplyPatch applies.
+type Patch []PatchOperation
+
+// MarshalJSON encodes the operation with only the members its op uses, so
+// that a nil Value is written as null for the operations which take one.
+func (this PatchOperation) MarshalJSON() ([]byte, error) {
+	switch this.Op {
+	case "add", "replace", "test":
+		return json.Marshal(struct {
+			Op    string      `json:"op"`
+			Path  string      `json:"path"`
+			Value interface{} `json:"value"`
+		}{this.Op, this.Path, this.Value})
+	case "move", "copy":
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			From string `json:"from"`
+			Path string `json:"path"`
+		}{this.Op, this.From, this.Path})
+	}
+	return json.Marshal(struct {
+		Op   string `json:"op"`
+		Path string `json:"path"`
+	}{this.Op, this.Path})
+}
+
+// ParsePatch parses an RFC 6902 JSON Patch document. Numbers in values are
+// kept exact, as with WithExactNumbers.
+func ParsePatch(data []byte) (Patch, error) {
+	var operations []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &operations); err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %w", err)
+	}
+
+	patch := make(Patch, len(operations))
+	for index, members := range operations {
+		operation := &patch[index]
+		for name, target := range map[string]*string{"op": &operation.Op, "path": &operation.Path, "from": &operation.From} {
+			raw, ok := members[name]
+			if !ok {
+				continue
+			}
+			if err := json.Unmarshal(raw, target); err != nil {
+				return nil, fmt.Errorf("invalid JSON patch operation %d: %s must be a string", index, name)
+			}
+		}
+
+		required := []string{"op", "path"}
+		switch operation.Op {
+		case "add", "replace", "test":
+			required = append(required, "value")
+		case "move", "copy":
+			required = append(required, "from")
+		}
+		for _, name := range required {
+			if _, ok := members[name]; !ok {
+				return nil, fmt.Errorf("invalid JSON patch operation %d: missing %s", index, name)
+			}
+		}
+
+		if raw, ok := members["value"]; ok {
+			decoder := json.NewDecoder(bytes.NewReader(raw))
+			decoder.UseNumber()
+			if err := decoder.Decode(&operation.Value); err != nil {
+				return nil, fmt.Errorf("invalid JSON patch operation %d: %w", index, err)
+			}
+			operation.Value = normalizeValue(operation.Value, true)
+		}
+	}
+	return patch, nil
+}
+
+// ApplyPatch applies an RFC 6902 JSON Patch to the map. Either every
+// operation is applied or, if any fails, including a "test" operation whose
+// value doesn't match, none of them are. For views returned by Sub, pointers
+// are relative to the view's prefix.
+func (c *ConfigMap) ApplyPatch(patch Patch) error {
+	segments, err := c.resolve("")
+	if err != nil {
+		return err
+	}
+
+	return c.update(segments, func(data map[string]interface{}) (map[string]interface{}, error) {
+		target, ok := lookupPath(data, segments)
+		if !ok {
+			target = make(map[string]interface{})
+		}
+
+		for index, operation := range patch {
+			if target, err = operation.apply(target); err != nil {
+				return nil, fmt.Errorf("cannot apply patch operation %d (%s %s): %w", index, operation.Op, operation.Path, err)
+			}
+		}
+		return replaceAt(data, segments, target)
+	})
+}
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to the map, merging
+// maps recursively, replacing other values and removing keys whose value in
+// the patch is nil. For views returned by Sub, the patch is applied to the
+// value at the view's prefix.
+func (c *ConfigMap) ApplyMergePatch(patch map[string]interface{}) error {
+	patch = normalizeMap(copyTree(patch).(map[string]interface{}), true)
+
+	segments, err := c.resolve("")
+	if err != nil {
+		return err
+	}
+
+	err = c.update(segments, func(data map[string]interface{}) (map[string]interface{}, error) {
+		target, _ := lookupPath(data, segments)
+		current, _ := target.(map[string]interface{})
+		return replaceAt(data, segments, DeepMerge(current, patch))
+	})
+	if err != nil {
+		return fmt.Errorf("cannot apply merge patch to %s: %w", describePath(segments), err)
+	}
+	return nil
+}
+
+// replaceAt returns data with the value at segments replaced by value, which
+// must be a map when segments is empty.
+func replaceAt(data map[string]interface{}, segments []pathSegment, value interface{}) (map[string]interface{}, error) {
+	if len(segments) == 0 {
+		result, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("the root must remain a map")
+		}
+		return result, nil
+	}
+	updated, err := setPath(data, segments, value, nil)
+	if err != nil {
+		return nil, err
+	}
+	return updated.(map[string]interface{}), nil
+}
+
+// CreatePatch returns a JSON Patch which turns old into new when applied to
+// it. Lists are compared by position.
+func CreatePatch(old, new *ConfigMap) Patch {
+	patch := Patch{}
+	generatePatch(&patch, "", diffData(old), diffData(new))
+	for index := range patch {
+		patch[index].Value = copyTree(patch[index].Value)
+	}
+	return patch
+}
+
+func generatePatch(patch *Patch, pointer string, old, new interface{}) {
+	if equalTrees(old, new) {
+		return
+	}
+
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		for _, key := range sortedKeys(oldMap) {
+			if _, ok := newMap[key]; !ok {
+				*patch = append(*patch, PatchOperation{Op: "remove", Path: pointer + "/" + escapePointerToken(key)})
+			}
+		}
+		for _, key := range sortedKeys(newMap) {
+			child := pointer + "/" + escapePointerToken(key)
+			if value, ok := oldMap[key]; ok {
+				generatePatch(patch, child, value, newMap[key])
+			} else {
+				*patch = append(*patch, PatchOperation{Op: "add", Path: child, Value: newMap[key]})
+			}
+		}
+		return
+	}
+
+	oldList, oldIsList := old.([]interface{})
+	newList, newIsList := new.([]interface{})
+	if oldIsList && newIsList {
+		for index := 0; index < len(oldList) && index < len(newList); index++ {
+			generatePatch(patch, pointer+"/"+strconv.Itoa(index), oldList[index], newList[index])
+		}
+		for index := len(oldList); index < len(newList); index++ {
+			*patch = append(*patch, PatchOperation{Op: "add", Path: pointer + "/" + strconv.Itoa(index), Value: newList[index]})
+		}
+		for index := len(oldList) - 1; index >= len(newList); index-- {
+			*patch = append(*patch, PatchOperation{Op: "remove", Path: pointer + "/" + strconv.Itoa(index)})
+		}
+		return
+	}
+
+	*patch = append(*patch, PatchOperation{Op: "replace", Path: pointer, Value: new})
+}
+
+// CreateMergePatch returns a JSON Merge Patch which turns old into new when
+// applied to it. Merge patches can't set a value to nil, since nil removes
+// the key, so such values are removed instead.
+func CreateMergePatch(old, new *ConfigMap) map[string]interface{} {
+	oldData, _ := diffData(old).(map[string]interface{})
+	newData, _ := diffData(new).(map[string]interface{})
+	return copyTree(generateMergePatch(oldData, newData)).(map[string]interface{})
+}
+
+func generateMergePatch(old, new map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	for key, value := range new {
+		existing, ok := old[key]
+		if ok && equalTrees(existing, value) {
+			continue
+		}
+		oldMap, oldIsMap := existing.(map[string]interface{})
+		newMap, newIsMap := value.(map[string]interface{})
+		if oldIsMap && newIsMap {
+			patch[key] = generateMergePatch(oldMap, newMap)
+		} else {
+			patch[key] = value
+		}
+	}
+	return patch
+}
+
+func (this PatchOperation) apply(document interface{}) (interface{}, error) {
+	path, err := parsePointer(this.Path)
+	if err != nil {
+		return nil, err
+	}
+	value := normalizeValue(copyTree(this.Value), true)
+
+	switch this.Op {
+	case "add":
+		return pointerAdd(document, path, value)
+	case "remove":
+		return pointerRemove(document, path)
+	case "replace":
+		return pointerReplace(document, path, value)
+	case "move", "copy":
+		from, err := parsePointer(this.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := pointerGet(document, from)
+		if err != nil {
+			return nil, fmt.Errorf("from %s: %w", this.From, err)
+		}
+		if this.Op == "move" {
+			if this.From == this.Path {
+				return document, nil
+			}
+			if strings.HasPrefix(this.Path, this.From+"/") {
+				return nil, errors.New("cannot move a value into itself")
+			}
+			if document, err = pointerRemove(document, from); err != nil {
+				return nil, err
+			}
+		}
+		return pointerAdd(document, path, value)
+	case "test":
+		actual, err := pointerGet(document, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equalTrees(actual, value) {
+			return nil, fmt.Errorf("%w: expected %s, got %s", ErrPatchTestFailed, formatChangeValue(value), formatChangeValue(actual))
+		}
+		return document, nil
+	}
+	return nil, fmt.Errorf("unknown operation %q", this.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for index, token := range tokens {
+		tokens[index] = pointerUnescaper.Replace(token)
+	}
+	return tokens, nil
+}
+
+var (
+	pointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+func escapePointerToken(token string) string {
+	return pointerEscaper.Replace(token)
+}
+
+// pointerIndex parses token as an index into a list of the given length,
+// where "-" is the index after the last element.
+func pointerIndex(token string, length int) (int, error) {
+	if token == "-" {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') || token[0] == '+' {
+		return 0, fmt.Errorf("invalid list index %q", token)
+	}
+	return index, nil
+}
+
+func pointerGet(node interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch current := node.(type) {
+		case map[string]interface{}:
+			value, ok := current[token]
+			if !ok {
+				return nil, ErrKeyNotFound
+			}
+			node = value
+		case []interface{}:
+			index, err := pointerIndex(token, len(current))
+			if err != nil {
+				return nil, err
+			}
+			if index >= len(current) {
+				return nil, ErrKeyNotFound
+			}
+			node = current[index]
+		default:
+			return nil, ErrKeyNotFound
+		}
+	}
+	return node, nil
+}
+
+// pointerUpdate returns node with the value at tokens updated by change,
+// which is given the parent of the value and the last token. As with
+// setPath, node itself is left unchanged.
+func pointerUpdate(node interface{}, tokens []string, change func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	token, rest := tokens[0], tokens[1:]
+	if len(rest) == 0 {
+		return change(node, token)
+	}
+
+	switch current := node.(type) {
+	case map[string]interface{}:
+		child, ok := current[token]
+		if !ok {
+			return nil, ErrKeyNotFound
+		}
+		updated, err := pointerUpdate(child, rest, change)
+		if err != nil {
+			return nil, err
+		}
+		result := copyMap(current)
+		result[token] = updated
+		return result, nil
+	case []interface{}:
+		index, err := pointerIndex(token, len(current))
+		if err != nil {
+			return nil, err
+		}
+		if index >= len(current) {
+			return nil, ErrKeyNotFound
+		}
+		updated, err := pointerUpdate(current[index], rest, change)
+		if err != nil {
+			return nil, err
+		}
+		result := copyList(current)
+		result[index] = updated
+		return result, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+func pointerAdd(document interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(document, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch current := parent.(type) {
+		case map[string]interface{}:
+			result := copyMap(current)
+			result[token] = value
+			return result, nil
+		case []interface{}:
+			index, err := pointerIndex(token, len(current))
+			if err != nil {
+				return nil, err
+			}
+			if index > len(current) {
+				return nil, fmt.Errorf("index %d is out of range for a list of length %d", index, len(current))
+			}
+			result := make([]interface{}, 0, len(current)+1)
+			result = append(append(append(result, current[:index]...), value), current[index:]...)
+			return result, nil
+		}
+		return nil, errors.New("parent is not a map or list")
+	})
+}
+
+func pointerRemove(document interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("cannot remove the root")
+	}
+	return pointerUpdate(document, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch current := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := current[token]; !ok {
+				return nil, ErrKeyNotFound
+			}
+			result := copyMap(current)
+			delete(result, token)
+			return result, nil
+		case []interface{}:
+			index, err := pointerIndex(token, len(current))
+			if err != nil {
+				return nil, err
+			}
+			if index >= len(current) {
+				return nil, ErrKeyNotFound
+			}
+			result := make([]interface{}, 0, len(current)-1)
+			return append(append(result, current[:index]...), current[index+1:]...), nil
+		}
+		return nil, ErrKeyNotFound
+	})
+}
+
+func pointerReplace(document interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if _, err := pointerGet(document, tokens); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(document, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch current := parent.(type) {
+		case map[string]interface{}:
+			result := copyMap(current)
+			result[token] = value
+			return result, nil
+		case []interface{}:
+			index, _ := pointerIndex(token, len(current))
+			result := copyList(current)
+			result[index] = value
+			return result, nil
+		}
+		return nil, ErrKeyNotFound
+	})
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,179 @@
+package prefer
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestConfigMapApplyPatch(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{
+		"server":  map[string]interface{}{"port": 8080, "debug": true},
+		"servers": []interface{}{"a", "c"},
+		"a/b":     map[string]interface{}{"~c": 1},
+	})
+
+	patch, err := ParsePatch([]byte(`[
+		{"op": "test", "path": "/server/port", "value": 8080.0},
+		{"op": "replace", "path": "/server/port", "value": 9090},
+		{"op": "remove", "path": "/server/debug"},
+		{"op": "add", "path": "/servers/1", "value": "b"},
+		{"op": "add", "path": "/servers/-", "value": "d"},
+		{"op": "copy", "from": "/server", "path": "/backup"},
+		{"op": "move", "from": "/a~1b/~0c", "path": "/moved"},
+		{"op": "add", "path": "/tls", "value": null}
+	]`))
+	checkTestError(t, err)
+	checkTestError(t, config.ApplyPatch(patch))
+
+	expected := map[string]interface{}{
+		"server":  map[string]interface{}{"port": int64(9090)},
+		"backup":  map[string]interface{}{"port": int64(9090)},
+		"servers": []interface{}{"a", "b", "c", "d"},
+		"a/b":     map[string]interface{}{},
+		"moved":   int64(1),
+		"tls":     nil,
+	}
+	if !reflect.DeepEqual(config.Data(), expected) {
+		t.Errorf("Expected %v, got %v", expected, config.Data())
+	}
+}
+
+func TestConfigMapApplyPatchIsAtomic(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{"name": "app", "port": 8080})
+
+	err := config.ApplyPatch(Patch{
+		{Op: "replace", Path: "/name", Value: "changed"},
+		{Op: "test", Path: "/port", Value: 9090},
+	})
+	if !errors.Is(err, ErrPatchTestFailed) {
+		t.Errorf("Expected ErrPatchTestFailed, got %v", err)
+	}
+	if name, _ := config.GetString("name"); name != "app" {
+		t.Error("Expected no operations to be applied when one fails")
+	}
+
+	failures := []Patch{
+		{{Op: "remove", Path: "/missing"}},
+		{{Op: "replace", Path: "/missing", Value: 1}},
+		{{Op: "add", Path: "/missing/child", Value: 1}},
+		{{Op: "remove", Path: ""}},
+		{{Op: "move", From: "/name", Path: "/name/child"}},
+		{{Op: "replace", Path: "", Value: "not a map"}},
+		{{Op: "frobnicate", Path: "/name"}},
+		{{Op: "add", Path: "name", Value: 1}},
+	}
+	for _, patch := range failures {
+		if err := config.ApplyPatch(patch); err == nil {
+			t.Errorf("Expected an error applying %+v", patch)
+		}
+	}
+}
+
+func TestConfigMapApplyPatchToView(t *testing.T) {
+	config := NewConfigMap(nil)
+	checkTestError(t, config.Sub("database").ApplyPatch(Patch{{Op: "add", Path: "/host", Value: "localhost"}}))
+
+	if host, _ := config.GetString("database.host"); host != "localhost" {
+		t.Error("Expected pointers to be relative to the view's prefix")
+	}
+}
+
+func TestParsePatchInvalid(t *testing.T) {
+	documents := []string{
+		`{"op": "add"}`,
+		`[{"op": "add", "path": "/a"}]`,
+		`[{"op": "move", "path": "/a"}]`,
+		`[{"op": 1, "path": "/a"}]`,
+	}
+	for _, document := range documents {
+		if _, err := ParsePatch([]byte(document)); err == nil {
+			t.Errorf("Expected an error parsing %s", document)
+		}
+	}
+}
+
+func TestConfigMapApplyMergePatch(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{
+		"server": map[string]interface{}{"port": 8080, "debug": true},
+		"tags":   []interface{}{"a"},
+	})
+
+	patch := map[string]interface{}{
+		"server": map[string]interface{}{"port": 9090, "debug": nil},
+		"tags":   []interface{}{"b"},
+		"tls":    map[string]interface{}{"enabled": true, "cert": nil},
+	}
+	checkTestError(t, config.ApplyMergePatch(patch))
+
+	expected := map[string]interface{}{
+		"server": map[string]interface{}{"port": int64(9090)},
+		"tags":   []interface{}{"b"},
+		"tls":    map[string]interface{}{"enabled": true},
+	}
+	if !reflect.DeepEqual(config.Data(), expected) {
+		t.Errorf("Expected %v, got %v", expected, config.Data())
+	}
+	if patch["server"].(map[string]interface{})["port"] != 9090 {
+		t.Error("Expected the patch not to be modified")
+	}
+}
+
+func TestCreatePatch(t *testing.T) {
+	old := NewConfigMap(map[string]interface{}{
+		"server": map[string]interface{}{"port": 8080, "debug": true},
+		"tags":   []interface{}{"a", "b", "c"},
+		"name":   "app",
+	})
+	new := NewConfigMap(map[string]interface{}{
+		"server": map[string]interface{}{"port": 9090, "tls": true},
+		"tags":   []interface{}{"a"},
+		"name":   1,
+	})
+
+	patch := CreatePatch(old, new)
+	encoded, err := json.Marshal(patch)
+	checkTestError(t, err)
+
+	expected := `[{"op":"replace","path":"/name","value":1},` +
+		`{"op":"remove","path":"/server/debug"},` +
+		`{"op":"replace","path":"/server/port","value":9090},` +
+		`{"op":"add","path":"/server/tls","value":true},` +
+		`{"op":"remove","path":"/tags/2"},` +
+		`{"op":"remove","path":"/tags/1"}]`
+	if string(encoded) != expected {
+		t.Errorf("Expected %s, got %s", expected, encoded)
+	}
+
+	checkTestError(t, old.ApplyPatch(patch))
+	if changes := Diff(old, new); len(changes) != 0 {
+		t.Errorf("Expected the patch to turn old into new, got %v", changes)
+	}
+}
+
+func TestCreateMergePatch(t *testing.T) {
+	old := NewConfigMap(map[string]interface{}{
+		"server": map[string]interface{}{"port": 8080, "debug": true},
+		"name":   "app",
+	})
+	new := NewConfigMap(map[string]interface{}{
+		"server": map[string]interface{}{"port": 9090},
+		"name":   "app",
+		"tags":   []interface{}{"a"},
+	})
+
+	patch := CreateMergePatch(old, new)
+	expected := map[string]interface{}{
+		"server": map[string]interface{}{"port": int64(9090), "debug": nil},
+		"tags":   []interface{}{"a"},
+	}
+	if !reflect.DeepEqual(patch, expected) {
+		t.Errorf("Expected %v, got %v", expected, patch)
+	}
+
+	checkTestError(t, old.ApplyMergePatch(patch))
+	if changes := Diff(old, new); len(changes) != 0 {
+		t.Errorf("Expected the patch to turn old into new, got %v", changes)
+	}
+}
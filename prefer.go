@@ -1,5 +1,11 @@
 package prefer
 
+import (
+	"errors"
+	"reflect"
+	"sort"
+)
+
 type filterable func(identifier string) bool
 
 // Option configures how configuration is loaded
@@ -12,12 +18,114 @@ func WithLoader(loader Loader) Option {
 	}
 }
 
+// WithJsonnetExtVar sets an external string variable which Jsonnet
+// configurations can read with std.extVar(name).
+func WithJsonnetExtVar(name, value string) Option {
+	return func(c *Configuration) {
+		if c.jsonnetExtVars == nil {
+			c.jsonnetExtVars = make(map[string]string)
+		}
+		c.jsonnetExtVars[name] = value
+	}
+}
+
+// WithJsonnetExtCode sets an external variable to the result of evaluating
+// the given Jsonnet code, for passing structured values to configurations.
+func WithJsonnetExtCode(name, code string) Option {
+	return func(c *Configuration) {
+		if c.jsonnetExtCode == nil {
+			c.jsonnetExtCode = make(map[string]string)
+		}
+		c.jsonnetExtCode[name] = code
+	}
+}
+
+// WithYAMLDocuments sets how YAML streams containing several documents are
+// read. YAMLAllDocuments requires dest to be a slice.
+func WithYAMLDocuments(mode YAMLDocumentMode) Option {
+	return func(c *Configuration) {
+		c.yamlDocuments = mode
+	}
+}
+
+// WithYAMLDocument selects the first YAML document in a stream whose
+// top-level key has the given value, such as ("env", "production").
+func WithYAMLDocument(key, value string) Option {
+	return func(c *Configuration) {
+		c.yamlDocuments = YAMLSelectDocument
+		c.yamlSelectKey = key
+		c.yamlSelectValue = value
+	}
+}
+
+// WithKeyNaming sets how configuration keys are derived from the names of
+// struct fields which don't have a prefer tag. Using any naming policy other
+// than KeyNamingDefault decodes structs through prefer tags for every format.
+func WithKeyNaming(naming KeyNaming) Option {
+	return func(c *Configuration) {
+		c.keyNaming = naming
+	}
+}
+
+// WithStrict rejects configurations containing keys which don't correspond to
+// any field of the destination, such as a misspelled "databse". Every unknown
+// key is reported in a single *UnknownKeysError.
+func WithStrict() Option {
+	return func(c *Configuration) {
+		c.strict = true
+	}
+}
+
+// WithExactNumbers decodes numbers into generic maps without losing
+// precision. Integers which don't fit in int64 are decoded as *big.Int, and
+// JSON numbers which aren't integers as json.Number, rather than as float64.
+func WithExactNumbers() Option {
+	return func(c *Configuration) {
+		c.exactNumbers = true
+	}
+}
+
+// WithEnvPrefix overrides decoded values with environment variables named
+// after the fields of the destination struct. With the prefix "MYAPP",
+// MYAPP__DATABASE__MAX_BODY_SIZE sets Database.MaxBodySize, and numbered
+// variables such as MYAPP__SERVERS__0__HOST set the items of slices, adding
+// items past the end of them when the numbering continues without a gap.
+// Other indexes outside a slice or array are reported as errors. An env tag
+// replaces a field's part of the name, and env:"-" leaves a field alone.
+//
+// Values are converted as EnvSource converts them for WithEnvType, so lists
+// may be given separated by commas or as JSON, and maps and whole structs as
+// JSON objects. Variables such as MYAPP__LABELS__TEAM also set single keys of
+// maps, spelled as an existing key which they match ignoring case and
+// underscores, or otherwise in lower case.
+func WithEnvPrefix(prefix string) Option {
+	return func(c *Configuration) {
+		c.envPrefix = prefix
+	}
+}
+
 type Configuration struct {
 	Identifier string
 
 	loader      Loader
 	Loaders     map[Loader]filterable
 	Serializers map[Serializer]SerializerFactory
+
+	jsonnetExtVars map[string]string
+	jsonnetExtCode map[string]string
+
+	yamlDocuments   YAMLDocumentMode
+	yamlSelectKey   string
+	yamlSelectValue string
+
+	keyNaming    KeyNaming
+	strict       bool
+	exactNumbers bool
+	envPrefix    string
+
+	// dependencies are files other than Identifier which were read during the
+	// last successful reload, and which are watched alongside it.
+	dependencies []string
 }
 
 // Load loads configuration from the given identifier into dest.
@@ -56,28 +164,90 @@ func NewConfiguration(identifier string, opts ...Option) *Configuration {
 }
 
 func (this *Configuration) Reload(dest interface{}) error {
+	identifier, content, err := this.load()
+	if err != nil {
+		return err
+	}
+
+	this.Identifier = identifier
+	return this.decode(identifier, content, dest)
+}
+
+// load reads the configuration's content using its loader, returning the
+// identifier it was found at.
+func (this *Configuration) load() (string, []byte, error) {
 	loader := this.loader
 	if loader == nil {
 		var err error
 		loader, err = NewLoader(this.Identifier)
 		if err != nil {
-			return err
+			return "", nil, err
 		}
 	}
+	return loader.Load()
+}
 
-	identifier, content, err := loader.Load()
-	if err != nil {
+// wrapDeserializeError turns errors from a serializer into a *ParseError.
+// Unknown keys in strict mode are not parse errors, so are returned as is.
+func wrapDeserializeError(identifier string, serializer Serializer, content []byte, err error) error {
+	var unknown *UnknownKeysError
+	if errors.As(err, &unknown) {
 		return err
 	}
+	return newParseError(identifier, serializer, content, err)
+}
 
-	this.Identifier = identifier
+// usesGenericDecoding reports whether dest should be decoded through a generic
+// tree, so that prefer tags and key naming apply regardless of the format.
+func (this *Configuration) usesGenericDecoding(dest interface{}) bool {
+	if dest == nil {
+		return false
+	}
+	if this.keyNaming != KeyNamingDefault {
+		return true
+	}
+	return usesPreferTags(reflect.TypeOf(dest))
+}
 
+// decode deserializes content loaded from identifier into dest, applying any
+// options which change how serializers behave.
+func (this *Configuration) decode(identifier string, content []byte, dest interface{}) error {
 	serializer, err := NewSerializer(identifier, content)
 	if err != nil {
 		return err
 	}
 
-	return serializer.Deserialize(content, dest)
+	if c, ok := serializer.(configurable); ok {
+		serializer = c.configure(identifier, this)
+	}
+
+	if this.usesGenericDecoding(dest) {
+		var tree interface{}
+		if err = serializer.Deserialize(content, &tree); err != nil {
+			return wrapDeserializeError(identifier, serializer, content, err)
+		}
+		if this.strict {
+			if err = checkUnknownKeys(tree, dest, tagName, this.keyNaming); err != nil {
+				return err
+			}
+		}
+		if err = decodeTree(tree, dest, this.keyNaming, false); err != nil {
+			return err
+		}
+	} else if err = serializer.Deserialize(content, dest); err != nil {
+		return wrapDeserializeError(identifier, serializer, content, err)
+	}
+
+	if err = this.applyEnv(dest); err != nil {
+		return err
+	}
+
+	this.dependencies = nil
+	if d, ok := serializer.(dependent); ok {
+		this.dependencies = d.Dependencies()
+	}
+
+	return nil
 }
 
 func (this *Configuration) Watch(dest interface{}, channel chan interface{}) error {
@@ -103,12 +273,29 @@ func (this *Configuration) WatchWithDone(dest interface{}, channel chan interfac
 		}
 	}
 
-	if err := loader.WatchWithContext(update, done); err != nil {
+	// Each watch of the loader, along with the dependencies of the last
+	// successful reload, lasts until its stop channel is closed
+	watch := func(dependencies []string) (chan struct{}, error) {
+		watched := loader
+		if d, ok := loader.(DependencyLoader); ok && len(dependencies) > 0 {
+			watched = d.WithDependencies(dependencies)
+		}
+		stop := make(chan struct{})
+		if err := watched.WatchWithContext(update, stop); err != nil {
+			return nil, err
+		}
+		return stop, nil
+	}
+
+	watched := this.dependencies
+	stop, err := watch(watched)
+	if err != nil {
 		return err
 	}
 
 	go func() {
 		defer close(channel)
+		defer func() { close(stop) }()
 		for {
 			select {
 			case _, ok := <-update:
@@ -121,13 +308,18 @@ func (this *Configuration) WatchWithDone(dest interface{}, channel chan interfac
 					continue
 				}
 
-				serializer, err := NewSerializer(identifier, content)
-				if err != nil {
+				if err = this.decode(identifier, content, dest); err != nil {
 					continue
 				}
 
-				if err = serializer.Deserialize(content, dest); err != nil {
-					continue
+				// Watch files which are now imported, and stop watching
+				// those which no longer are. The previous watch is kept
+				// if the new one can't be started.
+				if !sameFiles(watched, this.dependencies) {
+					if next, err := watch(this.dependencies); err == nil {
+						close(stop)
+						stop, watched = next, this.dependencies
+					}
 				}
 
 				this.Identifier = identifier
@@ -140,3 +332,20 @@ func (this *Configuration) WatchWithDone(dest interface{}, channel chan interfac
 
 	return nil
 }
+
+// sameFiles reports whether two lists of dependencies name the same files.
+func sameFiles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
@@ -715,3 +715,345 @@ func TestNewConfigurationWithOptions(t *testing.T) {
 		t.Error("Expected custom loader to be set")
 	}
 }
+
+func TestLoadJsonnetWithExtVars(t *testing.T) {
+	type Config struct {
+		Name string `json:"name"`
+		Env  string `json:"env"`
+	}
+
+	content := []byte(`{ name: "app-" + std.extVar("env"), env: std.extVar("env") }`)
+	loader := NewMemoryLoader("config.jsonnet", content)
+
+	var config Config
+	_, err := Load("unused", &config, WithLoader(loader), WithJsonnetExtVar("env", "production"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if config.Name != "app-production" || config.Env != "production" {
+		t.Error("Unexpected values from Jsonnet configuration:", config)
+	}
+}
+
+func TestLoadJsonnetWithExtCode(t *testing.T) {
+	type Config struct {
+		Replicas int `json:"replicas"`
+	}
+
+	loader := NewMemoryLoader("config.jsonnet", []byte(`{ replicas: std.extVar("scale").replicas * 2 }`))
+
+	var config Config
+	_, err := Load("unused", &config, WithLoader(loader), WithJsonnetExtCode("scale", "{ replicas: 3 }"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if config.Replicas != 6 {
+		t.Error("Expected 6 replicas, got:", config.Replicas)
+	}
+}
+
+func TestWatchWithDoneReloadsWhenJsonnetImportChanges(t *testing.T) {
+	type Config struct {
+		Name string `json:"name"`
+	}
+
+	tmpDir := t.TempDir()
+	libFile := filepath.Join(tmpDir, "common.libsonnet")
+	mainFile := filepath.Join(tmpDir, "config.jsonnet")
+
+	if err := os.WriteFile(libFile, []byte(`{ name: "initial" }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mainFile, []byte(`import "common.libsonnet"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{}
+	done := make(chan struct{})
+	defer close(done)
+
+	channel, err := WatchWithDone(mainFile, &config, done)
+	checkTestError(t, err)
+
+	select {
+	case <-channel:
+		if config.Name != "initial" {
+			t.Error("Expected initial name, got:", config.Name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for initial config")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.WriteFile(libFile, []byte(`{ name: "updated" }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-channel:
+		if config.Name != "updated" {
+			t.Error("Expected updated name, got:", config.Name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for import change to trigger a reload")
+	}
+}
+
+func TestWatchWithDoneFollowsChangedJsonnetImports(t *testing.T) {
+	type Config struct {
+		Name string `json:"name"`
+	}
+
+	tmpDir := t.TempDir()
+	firstFile := filepath.Join(tmpDir, "first.libsonnet")
+	secondFile := filepath.Join(tmpDir, "second.libsonnet")
+	mainFile := filepath.Join(tmpDir, "config.jsonnet")
+
+	for path, content := range map[string]string{
+		firstFile:  `{ name: "first" }`,
+		secondFile: `{ name: "second" }`,
+		mainFile:   `import "first.libsonnet"`,
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := Config{}
+	done := make(chan struct{})
+	defer close(done)
+
+	channel, err := WatchWithDone(mainFile, &config, done)
+	checkTestError(t, err)
+
+	expect := func(name string) {
+		t.Helper()
+		select {
+		case <-channel:
+			if config.Name != name {
+				t.Errorf("Expected name %q, got %q", name, config.Name)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for name %q", name)
+		}
+	}
+
+	expect("first")
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.WriteFile(mainFile, []byte(`import "second.libsonnet"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect("second")
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.WriteFile(secondFile, []byte(`{ name: "second, updated" }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect("second, updated")
+
+	if err := os.WriteFile(firstFile, []byte(`{ name: "first, updated" }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-channel:
+		t.Errorf("Expected files which are no longer imported not to be watched, got %q", config.Name)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestLoadWithYAMLDocumentOptions(t *testing.T) {
+	type Config struct {
+		Env  string `yaml:"env"`
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+	}
+
+	tmpDir := t.TempDir()
+	tmpFile := filepath.Join(tmpDir, "config.yaml")
+	content := "env: default\nhost: localhost\nport: 8080\n---\nenv: production\nhost: example.com\n"
+	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var selected Config
+	if _, err := Load(tmpFile, &selected, WithYAMLDocument("env", "production")); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if selected.Host != "example.com" || selected.Port != 0 {
+		t.Error("Expected only the production document, got:", selected)
+	}
+
+	var merged Config
+	if _, err := Load(tmpFile, &merged, WithYAMLDocuments(YAMLMergeDocuments)); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if merged.Host != "example.com" || merged.Port != 8080 {
+		t.Error("Expected merged documents, got:", merged)
+	}
+
+	var all []Config
+	if _, err := Load(tmpFile, &all, WithYAMLDocuments(YAMLAllDocuments)); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(all) != 2 || all[0].Env != "default" || all[1].Env != "production" {
+		t.Error("Expected all documents, got:", all)
+	}
+}
+
+func TestLoadWithPreferTagsDecodesScalarsIntoStrings(t *testing.T) {
+	type Config struct {
+		Port  string `prefer:"port"`
+		Debug string `prefer:"debug"`
+		Ratio string
+	}
+	content := []byte("port: 8080\ndebug: true\nRatio: 0.5\n")
+
+	var config Config
+	_, err := Load("unused", &config, WithLoader(NewMemoryLoader("config.yaml", content)))
+	checkTestError(t, err)
+	if config.Port != "8080" || config.Debug != "true" || config.Ratio != "0.5" {
+		t.Errorf("Expected numbers and booleans to decode into strings as YAML allows, got %+v", config)
+	}
+}
+
+func TestLoadWithEnvPrefix(t *testing.T) {
+	type Server struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	type Config struct {
+		Name        string        `json:"name"`
+		MaxBodySize int           `json:"maxBodySize"`
+		Timeout     time.Duration `json:"timeout"`
+		Tags        []string      `json:"tags"`
+		Secret      string        `json:"secret" env:"TOKEN"`
+		Ignored     string        `json:"ignored" env:"-"`
+		Database    struct {
+			Host  string `json:"host"`
+			Debug bool   `json:"debug"`
+		} `json:"database"`
+		Servers []Server `json:"servers"`
+		Cache   *Server  `json:"cache"`
+	}
+
+	variables := map[string]string{
+		"ENVBIND__MAX_BODY_SIZE":     "2048",
+		"ENVBIND__TIMEOUT":           "5s",
+		"ENVBIND__TAGS":              "a, b",
+		"ENVBIND__TOKEN":             "hunter2",
+		"ENVBIND__IGNORED":           "set",
+		"ENVBIND__DATABASE__DEBUG":   "true",
+		"ENVBIND__SERVERS__0__PORT":  "8080",
+		"ENVBIND__SERVERS__1__HOST":  "backup",
+		"ENVBIND__CACHE__HOST":       "redis",
+		"ENVBINDOTHER__NAME":         "unrelated",
+		"ENVBIND__DATABASE__UNKNOWN": "ignored",
+	}
+	for name, value := range variables {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	content := []byte(`{"name": "app", "maxBodySize": 1024, "database": {"host": "db"}, "servers": [{"host": "web", "port": 80}]}`)
+
+	var config Config
+	_, err := Load("unused", &config, WithLoader(NewMemoryLoader("config.json", content)), WithEnvPrefix("ENVBIND"))
+	checkTestError(t, err)
+
+	if config.Name != "app" || config.MaxBodySize != 2048 || config.Timeout != 5*time.Second {
+		t.Errorf("Expected values from the environment over the file, got %+v", config)
+	}
+	if len(config.Tags) != 2 || config.Tags[1] != "b" {
+		t.Errorf("Expected tags to be split on commas, got %v", config.Tags)
+	}
+	if config.Secret != "hunter2" || config.Ignored != "" {
+		t.Errorf("Expected env tags to rename and skip fields, got %q and %q", config.Secret, config.Ignored)
+	}
+	if config.Database.Host != "db" || !config.Database.Debug {
+		t.Errorf("Expected nested structs to be bound, got %+v", config.Database)
+	}
+	if len(config.Servers) != 2 || config.Servers[0] != (Server{"web", 8080}) || config.Servers[1].Host != "backup" {
+		t.Errorf("Expected numbered variables to set and add slice items, got %+v", config.Servers)
+	}
+	if config.Cache == nil || config.Cache.Host != "redis" {
+		t.Errorf("Expected nil pointers to be allocated, got %+v", config.Cache)
+	}
+
+	os.Setenv("ENVBIND__MAX_BODY_SIZE", "large")
+	if _, err := Load("unused", &config, WithLoader(NewMemoryLoader("config.json", content)), WithEnvPrefix("ENVBIND")); err == nil || !strings.Contains(err.Error(), "ENVBIND__MAX_BODY_SIZE") {
+		t.Errorf("Expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestLoadWithEnvPrefixRejectsIndexesPastTheEnd(t *testing.T) {
+	type Server struct {
+		Host string `json:"host"`
+	}
+	type Config struct {
+		Servers []Server  `json:"servers"`
+		Pair    [2]string `json:"pair"`
+	}
+	content := []byte(`{"servers": [{"host": "web"}]}`)
+
+	for _, name := range []string{"ENVINDEX__SERVERS__999999999999__HOST", "ENVINDEX__SERVERS__2__HOST", "ENVINDEX__PAIR__2"} {
+		os.Setenv(name, "value")
+		var config Config
+		_, err := Load("unused", &config, WithLoader(NewMemoryLoader("config.json", content)), WithEnvPrefix("ENVINDEX"))
+		os.Unsetenv(name)
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected a DecodeError naming %s, got %v", name, err)
+		}
+	}
+
+	os.Setenv("ENVINDEX__SERVERS__1__HOST", "backup")
+	defer os.Unsetenv("ENVINDEX__SERVERS__1__HOST")
+	var config Config
+	_, err := Load("unused", &config, WithLoader(NewMemoryLoader("config.json", content)), WithEnvPrefix("ENVINDEX"))
+	checkTestError(t, err)
+	if len(config.Servers) != 2 || config.Servers[1].Host != "backup" {
+		t.Errorf("Expected the next index to append a server, got %+v", config.Servers)
+	}
+}
+
+func TestLoadWithEnvPrefixSetsMapKeys(t *testing.T) {
+	type Config struct {
+		Labels  map[string]string         `json:"labels"`
+		Limits  map[string]map[string]int `json:"limits"`
+		Numbers map[int]string            `json:"numbers"`
+	}
+	variables := map[string]string{
+		"ENVMAP__LABELS__TEAM":        "core",
+		"ENVMAP__LABELS__COST_CENTER": "42",
+		"ENVMAP__LIMITS__API__RATE":   "10",
+	}
+	for name, value := range variables {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	content := []byte(`{"labels": {"costCenter": "1", "env": "prod"}}`)
+	var config Config
+	_, err := Load("unused", &config, WithLoader(NewMemoryLoader("config.json", content)), WithEnvPrefix("ENVMAP"))
+	checkTestError(t, err)
+
+	labels := config.Labels
+	if len(labels) != 3 || labels["team"] != "core" || labels["costCenter"] != "42" || labels["env"] != "prod" {
+		t.Errorf("Expected variables to set map keys, got %v", config.Labels)
+	}
+	if config.Limits["api"]["rate"] != 10 {
+		t.Errorf("Expected variables to set keys of nested maps, got %v", config.Limits)
+	}
+
+	os.Setenv("ENVMAP__NUMBERS__1", "one")
+	defer os.Unsetenv("ENVMAP__NUMBERS__1")
+	_, err = Load("unused", &config, WithLoader(NewMemoryLoader("config.json", content)), WithEnvPrefix("ENVMAP"))
+	if err == nil || !strings.Contains(err.Error(), "ENVMAP__NUMBERS__1") {
+		t.Errorf("Expected an error naming the variable for a map without string keys, got %v", err)
+	}
+}
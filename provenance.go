@@ -0,0 +1,315 @@
+package prefer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Origin is where a value in a ConfigMap built by ConfigBuilder came from.
+type Origin struct {
+	// Source is the kind of source which set the value: "file", "env",
+	// "flag" or "memory", or the String method or type of other sources.
+	// It is "set" for values changed after Build, such as by Set, and empty
+	// for maps which weren't built by a ConfigBuilder.
+	Source string
+	// Name is the path of the file, the name of the environment variable or
+	// the flag, such as "-database.host".
+	Name string
+	// Line is the line of the file at which the value was set, or 0 when
+	// it isn't known.
+	Line int
+	// Value is the value once this source had been merged.
+	Value interface{}
+}
+
+// String describes the origin as, for example, "file config.yaml:12" or
+// "env MYAPP__DATABASE__HOST".
+func (this Origin) String() string {
+	description := this.Source
+	if this.Name != "" {
+		description += " " + this.Name
+	}
+	if this.Line > 0 {
+		description += ":" + strconv.Itoa(this.Line)
+	}
+	return description
+}
+
+// Explanation is why a key has its value, as returned by ConfigMap.Explain.
+type Explanation struct {
+	Key   string
+	Value interface{}
+	// Origin is the source of the current value.
+	Origin Origin
+	// Overridden are the sources whose values Origin replaced, the most
+	// recent first.
+	Overridden []Origin
+}
+
+// String renders the explanation one line per source, as in:
+//
+//	database.host = "db.internal" from env MYAPP__DATABASE__HOST
+//	  overrides "localhost" from file config.yaml:3
+func (this Explanation) String() string {
+	var text strings.Builder
+	text.WriteString(this.Key + " = " + formatChangeValue(this.Value))
+	if this.Origin.Source != "" {
+		text.WriteString(" from " + this.Origin.String())
+	}
+	for _, origin := range this.Overridden {
+		text.WriteString("\n  overrides " + formatChangeValue(origin.Value) + " from " + origin.String())
+	}
+	return text.String()
+}
+
+// Explain returns where the value at key came from when the map was built
+// by a ConfigBuilder, along with the values it overrode from earlier
+// sources. Lists are tracked as single values, so the elements of a list
+// are explained by the list which contains them. Returns false when key
+// doesn't exist.
+func (c *ConfigMap) Explain(key string) (Explanation, bool) {
+	value, ok := c.Get(key)
+	if !ok {
+		return Explanation{}, false
+	}
+	explanation := Explanation{Key: key, Value: value}
+
+	root := c.root()
+	if root.origins == nil {
+		return explanation, true
+	}
+
+	segments, _ := c.resolve(key)
+	data := root.load()
+	path := concretePath(data, segments)
+	for length := len(path); length > 0; length-- {
+		origins, ok := root.origins[strings.Join(path[:length], keySeparator)]
+		if !ok {
+			continue
+		}
+
+		current, _ := lookupPath(data, segments[:length])
+		winner := origins[len(origins)-1]
+		if equalTrees(current, winner.Value) {
+			origins = origins[:len(origins)-1]
+		} else {
+			winner = Origin{Source: "set", Value: current}
+		}
+
+		winner.Value = copyTree(winner.Value)
+		explanation.Origin = winner
+		for index := len(origins) - 1; index >= 0; index-- {
+			origin := origins[index]
+			origin.Value = copyTree(origin.Value)
+			explanation.Overridden = append(explanation.Overridden, origin)
+		}
+		return explanation, true
+	}
+
+	explanation.Origin = Origin{Source: "set", Value: value}
+	return explanation, true
+}
+
+// concretePath formats segments as the keys and indexes Walk would use for
+// the value they address in data.
+func concretePath(data interface{}, segments []pathSegment) []string {
+	path := make([]string, 0, len(segments))
+	node := data
+	for _, segment := range segments {
+		switch current := node.(type) {
+		case map[string]interface{}:
+			path = append(path, formatKeySegment(segment.key))
+			node = current[segment.key]
+		case []interface{}:
+			index, _ := segment.listIndex(len(current))
+			path = append(path, strconv.Itoa(index))
+			node = current[index]
+		}
+	}
+	return path
+}
+
+// loadedSource is the data loaded from a source, and where it came from.
+type loadedSource struct {
+	data map[string]interface{}
+	// origin is where the source's values came from, and origins are more
+	// specific origins for some key paths and the values below them.
+	origin  Origin
+	origins map[string]Origin
+}
+
+// loadSource loads source, recording where its values came from when it
+// can say.
+func loadSource(source Source, opts []Option, lower map[string]interface{}) (*loadedSource, error) {
+	if s, ok := source.(originSource); ok {
+		return s.loadSource(opts, lower)
+	}
+	data, err := source.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &loadedSource{data: data, origin: Origin{Source: sourceName(source)}}, nil
+}
+
+// originFor returns the origin of the value at path.
+func (this *loadedSource) originFor(path []string) Origin {
+	for length := len(path); length > 0; length-- {
+		if origin, ok := this.origins[strings.Join(path[:length], keySeparator)]; ok {
+			return origin
+		}
+	}
+	return this.origin
+}
+
+// recordOrigins appends the origin of every value in merged which source
+// set or changed to its history, and forgets the history of values which no
+// longer exist. data is the source's data as it was merged.
+func recordOrigins(history map[string][]Origin, previous, merged, data map[string]interface{}, source *loadedSource) {
+	leaves := make(map[string]bool)
+	var walk func(path []string, value, old interface{}, existed bool, provided interface{}, isProvided bool)
+	walk = func(path []string, value, old interface{}, existed bool, provided interface{}, isProvided bool) {
+		if node, ok := value.(map[string]interface{}); ok && (len(node) > 0 || len(path) == 0) {
+			oldMap, _ := old.(map[string]interface{})
+			providedMap, _ := provided.(map[string]interface{})
+			for key, child := range node {
+				oldChild, childExisted := oldMap[key]
+				providedChild, childProvided := providedMap[key]
+				walk(append(path[:len(path):len(path)], formatKeySegment(key)), child, oldChild, childExisted, providedChild, childProvided)
+			}
+			return
+		}
+
+		key := strings.Join(path, keySeparator)
+		leaves[key] = true
+		if existed && !isProvided && equalTrees(old, value) {
+			return
+		}
+		origin := source.originFor(path)
+		origin.Value = value
+		history[key] = append(history[key], origin)
+	}
+	walk(nil, merged, previous, true, data, true)
+
+	for key := range history {
+		if !leaves[key] {
+			delete(history, key)
+		}
+	}
+}
+
+// sourceLines returns the line at which each key path is set in content,
+// for the formats which can say.
+func sourceLines(identifier string, content []byte) map[string]int {
+	serializer, err := NewSerializer(identifier, content)
+	if err != nil {
+		return nil
+	}
+	switch serializer.(type) {
+	case YAMLSerializer:
+		return yamlLines(content)
+	case JSONSerializer:
+		return jsonLines(content)
+	}
+	return nil
+}
+
+func yamlLines(content []byte) map[string]int {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	var document yaml.Node
+	if err := decoder.Decode(&document); err != nil || len(document.Content) == 0 {
+		return nil
+	}
+	// Lines in files with several documents depend on how they're combined
+	var next yaml.Node
+	if decoder.Decode(&next) != io.EOF {
+		return nil
+	}
+
+	lines := make(map[string]int)
+	var walk func(path []string, node *yaml.Node)
+	walk = func(path []string, node *yaml.Node) {
+		switch node.Kind {
+		case yaml.MappingNode:
+			for index := 0; index+1 < len(node.Content); index += 2 {
+				key, value := node.Content[index], node.Content[index+1]
+				name := strings.TrimSuffix(key.Value, "+")
+				if key.Value == "<<" || name == "" {
+					continue
+				}
+				child := append(path[:len(path):len(path)], formatKeySegment(name))
+				lines[strings.Join(child, keySeparator)] = key.Line
+				walk(child, value)
+			}
+		case yaml.SequenceNode:
+			for index, item := range node.Content {
+				child := append(path[:len(path):len(path)], strconv.Itoa(index))
+				lines[strings.Join(child, keySeparator)] = item.Line
+				walk(child, item)
+			}
+		}
+	}
+	walk(nil, document.Content[0])
+	return lines
+}
+
+func jsonLines(content []byte) map[string]int {
+	var newlines []int
+	for index, c := range content {
+		if c == '\n' {
+			newlines = append(newlines, index)
+		}
+	}
+	// lineAfter returns the line of the first token at or after offset
+	lineAfter := func(offset int64) int {
+		position := int(offset)
+		for position < len(content) && strings.IndexByte(" \t\r\n,:", content[position]) != -1 {
+			position++
+		}
+		return sort.SearchInts(newlines, position) + 1
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	lines := make(map[string]int)
+	var walk func(path []string) error
+	walk = func(path []string) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'):
+			for decoder.More() {
+				line := lineAfter(decoder.InputOffset())
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				child := append(path[:len(path):len(path)], formatKeySegment(key.(string)))
+				lines[strings.Join(child, keySeparator)] = line
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		case json.Delim('['):
+			for index := 0; decoder.More(); index++ {
+				child := append(path[:len(path):len(path)], strconv.Itoa(index))
+				lines[strings.Join(child, keySeparator)] = lineAfter(decoder.InputOffset())
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		}
+		return err
+	}
+	// Lines found before any syntax which only JSON5 allows are still right
+	walk(nil)
+	return lines
+}
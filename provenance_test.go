@@ -0,0 +1,102 @@
+package prefer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigMapExplain(t *testing.T) {
+	tmpDir := t.TempDir()
+	yamlFile := filepath.Join(tmpDir, "config.yaml")
+	jsonFile := filepath.Join(tmpDir, "local.json")
+
+	yamlContent := "name: app\ndatabase:\n  host: db.internal\n  port: 5432\nplugins: [a]\n"
+	if err := os.WriteFile(yamlFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jsonContent := "{\n  \"database\": {\n    \"host\": \"db.local\",\n    \"port\": 5432\n  }\n}\n"
+	if err := os.WriteFile(jsonFile, []byte(jsonContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("EXPLAINAPP__DATABASE__HOST", "db.env")
+	defer os.Unsetenv("EXPLAINAPP__DATABASE__HOST")
+
+	config, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{
+			"database": map[string]interface{}{"host": "localhost", "port": 5432},
+			"debug":    false,
+		}).
+		AddFile(yamlFile).
+		AddFile(jsonFile).
+		AddEnv("EXPLAINAPP").
+		Build()
+	checkTestError(t, err)
+
+	explanation, ok := config.Explain("database.host")
+	if !ok {
+		t.Fatal("Expected an explanation for database.host")
+	}
+	if explanation.Value != "db.env" || explanation.Origin.String() != "env EXPLAINAPP__DATABASE__HOST" {
+		t.Errorf("Expected the value from the environment, got %v from %v", explanation.Value, explanation.Origin)
+	}
+
+	expected := []Origin{
+		{Source: "file", Name: jsonFile, Line: 3, Value: "db.local"},
+		{Source: "file", Name: yamlFile, Line: 3, Value: "db.internal"},
+		{Source: "memory", Value: "localhost"},
+	}
+	if len(explanation.Overridden) != len(expected) {
+		t.Fatalf("Expected %d overridden values, got %v", len(expected), explanation.Overridden)
+	}
+	for index, origin := range expected {
+		if explanation.Overridden[index] != origin {
+			t.Errorf("Expected %+v, got %+v", origin, explanation.Overridden[index])
+		}
+	}
+
+	if port, _ := config.Explain("database.port"); port.Origin.Name != jsonFile || port.Origin.Line != 4 || len(port.Overridden) != 2 {
+		t.Errorf("Expected a file setting an equal value to take over, got %+v", port)
+	}
+	if debug, _ := config.Explain("debug"); debug.Origin.Source != "memory" || len(debug.Overridden) != 0 {
+		t.Errorf("Expected debug from the defaults, got %+v", debug)
+	}
+	if plugin, _ := config.Explain("plugins.0"); plugin.Origin.Line != 5 || plugin.Value != "a" {
+		t.Errorf("Expected list elements to be explained by their list, got %+v", plugin)
+	}
+	if host, _ := config.Sub("database").Explain("host"); host.Origin.Source != "env" {
+		t.Errorf("Expected views to explain keys below their prefix, got %+v", host)
+	}
+	if _, ok := config.Explain("missing"); ok {
+		t.Error("Expected no explanation for a missing key")
+	}
+
+	checkTestError(t, config.Set("database.host", "changed"))
+	changed, _ := config.Explain("database.host")
+	if changed.Origin.Source != "set" || len(changed.Overridden) != 4 {
+		t.Errorf("Expected values changed after Build to be reported, got %+v", changed)
+	}
+	if !strings.HasPrefix(changed.String(), `database.host = "changed" from set`+"\n  overrides \"db.env\" from env") {
+		t.Errorf("Unexpected rendering %q", changed.String())
+	}
+}
+
+func TestConfigMapExplainAfterDelete(t *testing.T) {
+	config, err := NewConfigBuilder().
+		AddDefaults(map[string]interface{}{"cache": map[string]interface{}{"host": "redis"}}).
+		AddSource(NewMemorySource(map[string]interface{}{"cache": nil})).
+		AddSource(NewMemorySource(map[string]interface{}{"cache": map[string]interface{}{"host": "memcached"}})).
+		Build()
+	checkTestError(t, err)
+
+	explanation, _ := config.Explain("cache.host")
+	if explanation.Value != "memcached" || len(explanation.Overridden) != 0 {
+		t.Errorf("Expected deleted values to be forgotten, got %+v", explanation)
+	}
+
+	if explanation, _ := NewConfigMap(map[string]interface{}{"a": 1}).Explain("a"); explanation.Origin.Source != "" {
+		t.Errorf("Expected no origin for maps which weren't built, got %+v", explanation)
+	}
+}
@@ -0,0 +1,646 @@
+package prefer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// QueryResult is a value matched by ConfigMap.Query, with the key path at
+// which it was found.
+type QueryResult struct {
+	Path  string
+	Value interface{}
+}
+
+// Query returns the values matched by a JSONPath-style expression, in the
+// order Walk would visit them. Each result's path is a concrete key path,
+// such as "servers.0.host", which can be passed to Get or Set. For views
+// returned by Sub, expressions and paths are relative to the view's prefix.
+//
+// Expressions are key paths, optionally starting with "$" for the root,
+// which may also contain:
+//
+//   - "*" or "[*]" to match every value in a map or list
+//   - ".." to match values at any depth, as in "..host"
+//   - "[start:end:step]" to slice lists, where each part is optional and may
+//     be negative to count from the end
+//   - "[?(filter)]" to match the values in a map or list for which filter is
+//     true, as in "servers[?(@.tls == false)].host"
+//
+// Filters compare "@", the value being filtered, or key paths below it such
+// as "@.port", against numbers, quoted strings, true, false and null using
+// ==, !=, <, <=, > and >=, and combine comparisons with &&, || and !. A path
+// on its own is true when the value exists and isn't null or false.
+func (c *ConfigMap) Query(expr string) ([]QueryResult, error) {
+	steps, err := parseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := c.Get("")
+	if !ok {
+		return nil, nil
+	}
+
+	results := []QueryResult{{Value: root}}
+	for _, step := range steps {
+		var next []QueryResult
+		for _, result := range results {
+			if step.recursive {
+				walkValue(result.Path, result.Value, func(path string, value interface{}) error {
+					next = step.selector.appendMatches(next, path, value)
+					return nil
+				})
+			} else {
+				next = step.selector.appendMatches(next, result.Path, result.Value)
+			}
+		}
+		results = next
+	}
+	return results, nil
+}
+
+// queryStep selects values from the results of the previous step, or from
+// each of their descendants too when recursive.
+type queryStep struct {
+	recursive bool
+	selector  querySelector
+}
+
+type querySelector interface {
+	appendMatches(results []QueryResult, path string, node interface{}) []QueryResult
+}
+
+type keySelector struct{ key string }
+
+func (this keySelector) appendMatches(results []QueryResult, path string, node interface{}) []QueryResult {
+	switch current := node.(type) {
+	case map[string]interface{}:
+		if value, ok := current[this.key]; ok {
+			results = append(results, QueryResult{joinPath(path, this.key), value})
+		}
+	case []interface{}:
+		if index, err := strconv.Atoi(this.key); err == nil {
+			return indexSelector{index}.appendMatches(results, path, node)
+		}
+	}
+	return results
+}
+
+type indexSelector struct{ index int }
+
+func (this indexSelector) appendMatches(results []QueryResult, path string, node interface{}) []QueryResult {
+	list, ok := node.([]interface{})
+	if !ok {
+		return results
+	}
+	index := this.index
+	if index < 0 {
+		index += len(list)
+	}
+	if index < 0 || index >= len(list) {
+		return results
+	}
+	return append(results, QueryResult{joinPath(path, strconv.Itoa(index)), list[index]})
+}
+
+type wildcardSelector struct{}
+
+func (this wildcardSelector) appendMatches(results []QueryResult, path string, node interface{}) []QueryResult {
+	return appendChildren(results, path, node, func(interface{}) bool { return true })
+}
+
+type sliceSelector struct {
+	start, end, step *int
+}
+
+func (this sliceSelector) appendMatches(results []QueryResult, path string, node interface{}) []QueryResult {
+	list, ok := node.([]interface{})
+	if !ok {
+		return results
+	}
+
+	step := 1
+	if this.step != nil {
+		step = *this.step
+	}
+	if step == 0 {
+		return results
+	}
+
+	bound := func(value *int, fallback int) int {
+		if value == nil {
+			return fallback
+		}
+		index := *value
+		if index < 0 {
+			index += len(list)
+		}
+		if step > 0 {
+			return min(max(index, 0), len(list))
+		}
+		return min(max(index, -1), len(list)-1)
+	}
+
+	if step > 0 {
+		for index := bound(this.start, 0); index < bound(this.end, len(list)); index += step {
+			results = append(results, QueryResult{joinPath(path, strconv.Itoa(index)), list[index]})
+		}
+	} else {
+		for index := bound(this.start, len(list)-1); index > bound(this.end, -1); index += step {
+			results = append(results, QueryResult{joinPath(path, strconv.Itoa(index)), list[index]})
+		}
+	}
+	return results
+}
+
+type filterSelector struct{ filter queryFilter }
+
+func (this filterSelector) appendMatches(results []QueryResult, path string, node interface{}) []QueryResult {
+	return appendChildren(results, path, node, this.filter.matches)
+}
+
+// appendChildren appends the values in a map, in key order, or in a list
+// for which keep returns true.
+func appendChildren(results []QueryResult, path string, node interface{}, keep func(interface{}) bool) []QueryResult {
+	switch current := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(current))
+		for key := range current {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if keep(current[key]) {
+				results = append(results, QueryResult{joinPath(path, key), current[key]})
+			}
+		}
+	case []interface{}:
+		for index, item := range current {
+			if keep(item) {
+				results = append(results, QueryResult{joinPath(path, strconv.Itoa(index)), item})
+			}
+		}
+	}
+	return results
+}
+
+// queryFilter is a filter expression, evaluated against each candidate.
+type queryFilter interface {
+	matches(value interface{}) bool
+}
+
+type andFilter struct{ left, right queryFilter }
+
+func (this andFilter) matches(value interface{}) bool {
+	return this.left.matches(value) && this.right.matches(value)
+}
+
+type orFilter struct{ left, right queryFilter }
+
+func (this orFilter) matches(value interface{}) bool {
+	return this.left.matches(value) || this.right.matches(value)
+}
+
+type notFilter struct{ filter queryFilter }
+
+func (this notFilter) matches(value interface{}) bool {
+	return !this.filter.matches(value)
+}
+
+// queryOperand is either a literal or a path relative to the candidate.
+type queryOperand struct {
+	relative bool
+	path     []pathSegment
+	literal  interface{}
+}
+
+func (this queryOperand) resolve(value interface{}) (interface{}, bool) {
+	if !this.relative {
+		return this.literal, true
+	}
+	return lookupPath(value, this.path)
+}
+
+type existsFilter struct{ operand queryOperand }
+
+func (this existsFilter) matches(value interface{}) bool {
+	result, ok := this.operand.resolve(value)
+	return ok && result != nil && result != false
+}
+
+type compareFilter struct {
+	operator    string
+	left, right queryOperand
+}
+
+func (this compareFilter) matches(value interface{}) bool {
+	left, leftOK := this.left.resolve(value)
+	right, rightOK := this.right.resolve(value)
+
+	switch this.operator {
+	case "==":
+		return leftOK == rightOK && (!leftOK || equalTrees(left, right))
+	case "!=":
+		return leftOK != rightOK || (leftOK && !equalTrees(left, right))
+	}
+	if !leftOK || !rightOK {
+		return false
+	}
+
+	var order int
+	switch {
+	case valueKind(left) == "number" && valueKind(right) == "number":
+		if _, ok := bigFloat(left); !ok {
+			return false
+		}
+		if _, ok := bigFloat(right); !ok {
+			return false
+		}
+		order = compareNumbers(left, right)
+	case valueKind(left) == "string" && valueKind(right) == "string":
+		order = strings.Compare(left.(string), right.(string))
+	default:
+		return false
+	}
+
+	switch this.operator {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+// queryParser parses query expressions.
+type queryParser struct {
+	expr     string
+	position int
+}
+
+func parseQuery(expr string) ([]queryStep, error) {
+	parser := &queryParser{expr: expr}
+	rooted := parser.consume("$")
+
+	var steps []queryStep
+	first := true
+	for !parser.done() {
+		step := queryStep{}
+		switch {
+		case parser.consume(".."):
+			step.recursive = true
+			if parser.peek() == '[' {
+				break
+			}
+			selector, err := parser.parseName()
+			if err != nil {
+				return nil, err
+			}
+			step.selector = selector
+		case parser.consume("."):
+			if first && !rooted {
+				return nil, parser.errorf("unexpected %q", '.')
+			}
+			selector, err := parser.parseName()
+			if err != nil {
+				return nil, err
+			}
+			step.selector = selector
+		case parser.peek() == '[':
+		default:
+			if !first || rooted {
+				return nil, parser.errorf("unexpected %q", parser.peek())
+			}
+			selector, err := parser.parseName()
+			if err != nil {
+				return nil, err
+			}
+			step.selector = selector
+		}
+
+		if step.selector == nil {
+			selector, err := parser.parseBracket()
+			if err != nil {
+				return nil, err
+			}
+			step.selector = selector
+		}
+		steps = append(steps, step)
+		first = false
+	}
+	return steps, nil
+}
+
+func (this *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid query %q: %s at offset %d", this.expr, fmt.Sprintf(format, args...), this.position)
+}
+
+func (this *queryParser) done() bool {
+	return this.position >= len(this.expr)
+}
+
+func (this *queryParser) peek() byte {
+	if this.done() {
+		return 0
+	}
+	return this.expr[this.position]
+}
+
+func (this *queryParser) consume(text string) bool {
+	if strings.HasPrefix(this.expr[this.position:], text) {
+		this.position += len(text)
+		return true
+	}
+	return false
+}
+
+func (this *queryParser) skipSpace() {
+	for !this.done() && (this.peek() == ' ' || this.peek() == '\t') {
+		this.position++
+	}
+}
+
+func (this *queryParser) expect(text string) error {
+	this.skipSpace()
+	if !this.consume(text) {
+		if this.done() {
+			return this.errorf("expected %s", text)
+		}
+		return this.errorf("expected %s but found %q", text, this.peek())
+	}
+	return nil
+}
+
+// parseName parses a map key after a dot, or "*".
+func (this *queryParser) parseName() (querySelector, error) {
+	switch this.peek() {
+	case '"', '\'':
+		text, end, err := parseQuoted(this.expr, this.position)
+		if err != nil {
+			return nil, this.errorf("unclosed quote")
+		}
+		this.position = end
+		return keySelector{text}, nil
+	case '*':
+		this.position++
+		return wildcardSelector{}, nil
+	}
+
+	var text strings.Builder
+	for !this.done() && this.peek() != '.' && this.peek() != '[' {
+		if this.peek() == '\\' && this.position+1 < len(this.expr) {
+			this.position++
+		}
+		text.WriteByte(this.peek())
+		this.position++
+	}
+	if text.Len() == 0 {
+		return nil, this.errorf("empty segment")
+	}
+	return keySelector{text.String()}, nil
+}
+
+// parseBracket parses a bracketed selector: a quoted key, "*", an index, a
+// slice or a filter.
+func (this *queryParser) parseBracket() (querySelector, error) {
+	this.position++
+	this.skipSpace()
+
+	var selector querySelector
+	switch this.peek() {
+	case '"', '\'':
+		text, end, err := parseQuoted(this.expr, this.position)
+		if err != nil {
+			return nil, this.errorf("unclosed quote")
+		}
+		this.position = end
+		selector = keySelector{text}
+	case '*':
+		this.position++
+		selector = wildcardSelector{}
+	case '?':
+		this.position++
+		filter, err := this.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		selector = filterSelector{filter}
+	default:
+		var err error
+		if selector, err = this.parseIndexOrSlice(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := this.expect("]"); err != nil {
+		return nil, err
+	}
+	return selector, nil
+}
+
+func (this *queryParser) parseIndexOrSlice() (querySelector, error) {
+	var parts [3]*int
+	count := 0
+	for {
+		this.skipSpace()
+		start := this.position
+		if this.peek() == '-' || this.peek() == '+' {
+			this.position++
+		}
+		for !this.done() && this.peek() >= '0' && this.peek() <= '9' {
+			this.position++
+		}
+		if text := this.expr[start:this.position]; text != "" {
+			value, err := strconv.Atoi(text)
+			if err != nil {
+				this.position = start
+				return nil, this.errorf("%q is not a list index", text)
+			}
+			parts[count] = &value
+		}
+		count++
+
+		this.skipSpace()
+		if count == len(parts) || !this.consume(":") {
+			break
+		}
+	}
+
+	if count == 1 {
+		if parts[0] == nil {
+			return nil, this.errorf("expected an index, slice, filter or quoted key")
+		}
+		return indexSelector{*parts[0]}, nil
+	}
+	return sliceSelector{parts[0], parts[1], parts[2]}, nil
+}
+
+func (this *queryParser) parseOr() (queryFilter, error) {
+	left, err := this.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		this.skipSpace()
+		if !this.consume("||") {
+			return left, nil
+		}
+		right, err := this.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+}
+
+func (this *queryParser) parseAnd() (queryFilter, error) {
+	left, err := this.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		this.skipSpace()
+		if !this.consume("&&") {
+			return left, nil
+		}
+		right, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+}
+
+func (this *queryParser) parseUnary() (queryFilter, error) {
+	this.skipSpace()
+	if this.peek() == '!' && !strings.HasPrefix(this.expr[this.position:], "!=") {
+		this.position++
+		filter, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{filter}, nil
+	}
+	if this.consume("(") {
+		filter, err := this.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := this.expect(")"); err != nil {
+			return nil, err
+		}
+		return filter, nil
+	}
+
+	left, err := this.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	this.skipSpace()
+	for _, operator := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if this.consume(operator) {
+			right, err := this.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return compareFilter{operator, left, right}, nil
+		}
+	}
+	if !left.relative {
+		return nil, this.errorf("expected a comparison")
+	}
+	return existsFilter{left}, nil
+}
+
+func (this *queryParser) parseOperand() (queryOperand, error) {
+	this.skipSpace()
+	switch c := this.peek(); {
+	case c == '@':
+		this.position++
+		path, err := this.parseRelativePath()
+		return queryOperand{relative: true, path: path}, err
+	case c == '"' || c == '\'':
+		text, end, err := parseQuoted(this.expr, this.position)
+		if err != nil {
+			return queryOperand{}, this.errorf("unclosed quote")
+		}
+		this.position = end
+		return queryOperand{literal: text}, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := this.position
+		this.position++
+		for !this.done() && strings.IndexByte("0123456789.eE+-", this.peek()) != -1 {
+			this.position++
+		}
+		text := this.expr[start:this.position]
+		if !json.Valid([]byte(text)) {
+			this.position = start
+			return queryOperand{}, this.errorf("%q is not a number", text)
+		}
+		return queryOperand{literal: normalizeNumber(text, true)}, nil
+	}
+
+	for literal, value := range map[string]interface{}{"true": true, "false": false, "null": nil} {
+		if this.consume(literal) {
+			return queryOperand{literal: value}, nil
+		}
+	}
+	if this.done() {
+		return queryOperand{}, this.errorf("expected a value")
+	}
+	return queryOperand{}, this.errorf("unexpected %q", this.peek())
+}
+
+// parseRelativePath parses the key path after "@" in a filter, made of
+// dotted names, quoted keys and list indexes.
+func (this *queryParser) parseRelativePath() ([]pathSegment, error) {
+	var path []pathSegment
+	for {
+		switch {
+		case this.consume("."):
+			if c := this.peek(); c == '"' || c == '\'' {
+				text, end, err := parseQuoted(this.expr, this.position)
+				if err != nil {
+					return nil, this.errorf("unclosed quote")
+				}
+				this.position = end
+				path = append(path, pathSegment{kind: segmentQuoted, key: text})
+				continue
+			}
+			start := this.position
+			for !this.done() && isQueryNameByte(this.peek()) {
+				this.position++
+			}
+			if start == this.position {
+				return nil, this.errorf("empty segment")
+			}
+			path = append(path, pathSegment{kind: segmentBare, key: this.expr[start:this.position]})
+		case this.peek() == '[':
+			selector, err := this.parseBracket()
+			if err != nil {
+				return nil, err
+			}
+			switch s := selector.(type) {
+			case keySelector:
+				path = append(path, pathSegment{kind: segmentQuoted, key: s.key})
+			case indexSelector:
+				path = append(path, pathSegment{kind: segmentIndex, index: s.index})
+			default:
+				return nil, this.errorf("filters may only use keys and indexes")
+			}
+		default:
+			return path, nil
+		}
+	}
+}
+
+func isQueryNameByte(c byte) bool {
+	return c == '_' || c == '-' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
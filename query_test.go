@@ -0,0 +1,99 @@
+package prefer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func queryTestConfig() *ConfigMap {
+	return NewConfigMap(map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"host": "a", "port": 80, "tls": false},
+			map[string]interface{}{"host": "b", "port": 443, "tls": true},
+			map[string]interface{}{"host": "c", "port": 8080, "tls": false, "tags": []interface{}{"beta"}},
+		},
+		"database": map[string]interface{}{
+			"host":     "db",
+			"replicas": []interface{}{map[string]interface{}{"host": "replica"}},
+		},
+		"a.b": 1,
+	})
+}
+
+func queryPaths(t *testing.T, config *ConfigMap, expr string) []string {
+	t.Helper()
+	results, err := config.Query(expr)
+	checkTestError(t, err)
+	paths := []string{}
+	for _, result := range results {
+		paths = append(paths, result.Path)
+	}
+	return paths
+}
+
+func TestConfigMapQuery(t *testing.T) {
+	config := queryTestConfig()
+
+	cases := map[string][]string{
+		"servers[*].host":                            {"servers.0.host", "servers.1.host", "servers.2.host"},
+		"$.servers.*.host":                           {"servers.0.host", "servers.1.host", "servers.2.host"},
+		"servers[?(@.tls == false)].host":            {"servers.0.host", "servers.2.host"},
+		"servers[?@.port >= 443 && !@.tls].host":     {"servers.2.host"},
+		"servers[?(@.tags)].host":                    {"servers.2.host"},
+		"servers[?(@.host == 'a' || @.port > 1000)]": {"servers.0", "servers.2"},
+		"servers[?(@.tags[0] == \"beta\")].port":     {"servers.2.port"},
+		"..host":                                     {"database.host", "database.replicas.0.host", "servers.0.host", "servers.1.host", "servers.2.host"},
+		"database..host":                             {"database.host", "database.replicas.0.host"},
+		"servers[1:].host":                           {"servers.1.host", "servers.2.host"},
+		"servers[-1].host":                           {"servers.2.host"},
+		"servers[::-2].host":                         {"servers.2.host", "servers.0.host"},
+		"servers.1.port":                             {"servers.1.port"},
+		"['a.b']":                                    {`"a.b"`},
+		"missing[*]":                                 {},
+		"$":                                          {""},
+	}
+	for expr, expected := range cases {
+		if paths := queryPaths(t, config, expr); !reflect.DeepEqual(paths, expected) {
+			t.Errorf("Expected %s to match %v, got %v", expr, expected, paths)
+		}
+	}
+}
+
+func TestConfigMapQueryResultsCanBeSet(t *testing.T) {
+	config := queryTestConfig()
+
+	results, err := config.Query("servers[?(@.tls == false)].tls")
+	checkTestError(t, err)
+	for _, result := range results {
+		if result.Value != false {
+			t.Errorf("Expected the matched value, got %v", result.Value)
+		}
+		checkTestError(t, config.Set(result.Path, true))
+	}
+
+	if paths := queryPaths(t, config, "servers[?(!@.tls)]"); len(paths) != 0 {
+		t.Errorf("Expected every server to use TLS, got %v", paths)
+	}
+	if paths := queryPaths(t, config.Sub("database"), "replicas[0].host"); !reflect.DeepEqual(paths, []string{"replicas.0.host"}) {
+		t.Errorf("Expected paths relative to the view, got %v", paths)
+	}
+}
+
+func TestConfigMapQueryInvalid(t *testing.T) {
+	config := queryTestConfig()
+
+	for _, expr := range []string{
+		"servers[",
+		"servers[?(@.tls == )]",
+		"servers[?(@.tls]",
+		"servers[abc]",
+		".servers",
+		"servers..",
+		"servers[?(1)]",
+		"servers[?(@[*])]",
+	} {
+		if _, err := config.Query(expr); err == nil {
+			t.Errorf("Expected an error for %s", expr)
+		}
+	}
+}
@@ -5,10 +5,16 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"path"
 	"reflect"
+	"sort"
+	"strings"
 
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/go-jsonnet"
 	"github.com/pelletier/go-toml/v2"
+	"github.com/vmihailenco/msgpack/v5"
 	"github.com/yosuke-furukawa/json5/encoding/json5"
 	"gopkg.in/ini.v1"
 	"gopkg.in/yaml.v3"
@@ -21,11 +27,69 @@ type Serializer interface {
 	Deserialize([]byte, interface{}) error
 }
 
-type YAMLSerializer struct{}
-type XMLSerializer struct{}
-type INISerializer struct{}
-type JSONSerializer struct{}
-type TOMLSerializer struct{}
+// YAMLDocumentMode controls how YAML streams with several documents are read.
+type YAMLDocumentMode int
+
+const (
+	// YAMLFirstDocument reads only the first document in the stream.
+	YAMLFirstDocument YAMLDocumentMode = iota
+	// YAMLAllDocuments reads every document as an element of a list.
+	YAMLAllDocuments
+	// YAMLMergeDocuments deep merges the documents, later ones overriding earlier ones.
+	YAMLMergeDocuments
+	// YAMLSelectDocument reads the first document whose top-level
+	// SelectKey has the value SelectValue.
+	YAMLSelectDocument
+)
+
+// Serializers with a Strict field reject keys which have no corresponding
+// field in the destination, reporting all of them in an *UnknownKeysError.
+// Those with an ExactNumbers field decode numbers into generic trees without
+// losing precision, as *big.Int and json.Number where they don't fit in int64
+// and float64.
+
+type YAMLSerializer struct {
+	Documents    YAMLDocumentMode
+	SelectKey    string
+	SelectValue  string
+	Strict       bool
+	ExactNumbers bool
+}
+type XMLSerializer struct {
+	Strict bool
+}
+type INISerializer struct {
+	Strict bool
+}
+type JSONSerializer struct {
+	Strict       bool
+	ExactNumbers bool
+}
+type TOMLSerializer struct {
+	Strict bool
+}
+type CBORSerializer struct {
+	ExactNumbers bool
+}
+type MsgPackSerializer struct {
+	ExactNumbers bool
+}
+
+// JsonnetSerializer evaluates Jsonnet and deserializes the resulting JSON.
+// Filename is used to resolve relative imports; when it is empty the content
+// is evaluated as an anonymous snippet.
+type JsonnetSerializer struct {
+	Filename string
+	ExtVars  map[string]string
+	ExtCode  map[string]string
+	JPaths   []string
+	Strict   bool
+	// ExactNumbers has no effect on numbers computed by Jsonnet itself,
+	// which are always float64.
+	ExactNumbers bool
+
+	dependencies []string
+}
 
 type SerializerFactory func() Serializer
 
@@ -36,12 +100,27 @@ func NewSerializer(identifier string, content []byte) (serializer Serializer, er
 	factory, ok := defaultSerializers[extension]
 
 	if !ok {
-		return nil, errors.New("No matching serializer for " + identifier)
+		factory, ok = sniffSerializer(content)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", ErrUnsupportedFormat, identifier)
 	}
 
 	return factory(), nil
 }
 
+// serializerExtensions returns the extensions with a default serializer, in
+// a stable order.
+func serializerExtensions() []string {
+	extensions := make([]string, 0, len(defaultSerializers))
+	for extension := range defaultSerializers {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
 func NewYAMLSerializer() Serializer {
 	return YAMLSerializer{}
 }
@@ -62,12 +141,205 @@ func NewTOMLSerializer() Serializer {
 	return TOMLSerializer{}
 }
 
+func NewCBORSerializer() Serializer {
+	return CBORSerializer{}
+}
+
+func NewMsgPackSerializer() Serializer {
+	return MsgPackSerializer{}
+}
+
+func NewJsonnetSerializer() Serializer {
+	return &JsonnetSerializer{}
+}
+
+// configurable is implemented by serializers whose behavior depends on the
+// options of the Configuration using them or on the identifier being loaded.
+type configurable interface {
+	configure(identifier string, configuration *Configuration) Serializer
+}
+
+// dependent is implemented by serializers which read files other than the one
+// they were given, such as Jsonnet imports.
+type dependent interface {
+	Dependencies() []string
+}
+
 func (this YAMLSerializer) Serialize(input interface{}) ([]byte, error) {
 	return yaml.Marshal(input)
 }
 
 func (this YAMLSerializer) Deserialize(input []byte, obj interface{}) error {
-	return yaml.Unmarshal(input, obj)
+	if err := this.deserialize(input, obj); err != nil {
+		return err
+	}
+	normalizeGeneric(obj, this.ExactNumbers)
+	return nil
+}
+
+func (this YAMLSerializer) deserialize(input []byte, obj interface{}) error {
+	if this.Strict {
+		lenient := this
+		lenient.Strict = false
+		if err := checkStrict(lenient, input, obj, "yaml"); err != nil {
+			return err
+		}
+	}
+
+	if this.Documents == YAMLFirstDocument {
+		if !this.Strict {
+			return yaml.Unmarshal(input, obj)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(input))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(obj); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	}
+
+	documents, err := decodeYAMLDocuments(input)
+	if err != nil {
+		return err
+	}
+
+	switch this.Documents {
+	case YAMLAllDocuments:
+		sequence := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: documents}
+		return sequence.Decode(obj)
+	case YAMLMergeDocuments:
+		var merged *yaml.Node
+		for _, document := range documents {
+			merged = mergeYAMLNodes(merged, document)
+		}
+		if merged == nil {
+			return nil
+		}
+		return merged.Decode(obj)
+	case YAMLSelectDocument:
+		for _, document := range documents {
+			if yamlDocumentMatches(document, this.SelectKey, this.SelectValue) {
+				return document.Decode(obj)
+			}
+		}
+		return fmt.Errorf("no YAML document has %s: %s", this.SelectKey, this.SelectValue)
+	}
+
+	return fmt.Errorf("unknown YAML document mode %d", this.Documents)
+}
+
+func (this YAMLSerializer) configure(identifier string, configuration *Configuration) Serializer {
+	this.Documents = configuration.yamlDocuments
+	this.SelectKey = configuration.yamlSelectKey
+	this.SelectValue = configuration.yamlSelectValue
+	this.Strict = configuration.strict
+	this.ExactNumbers = configuration.exactNumbers
+	return this
+}
+
+func (this XMLSerializer) configure(identifier string, configuration *Configuration) Serializer {
+	this.Strict = configuration.strict
+	return this
+}
+
+func (this INISerializer) configure(identifier string, configuration *Configuration) Serializer {
+	this.Strict = configuration.strict
+	return this
+}
+
+func (this JSONSerializer) configure(identifier string, configuration *Configuration) Serializer {
+	this.Strict = configuration.strict
+	this.ExactNumbers = configuration.exactNumbers
+	return this
+}
+
+func (this TOMLSerializer) configure(identifier string, configuration *Configuration) Serializer {
+	this.Strict = configuration.strict
+	return this
+}
+
+func (this CBORSerializer) configure(identifier string, configuration *Configuration) Serializer {
+	this.ExactNumbers = configuration.exactNumbers
+	return this
+}
+
+func (this MsgPackSerializer) configure(identifier string, configuration *Configuration) Serializer {
+	this.ExactNumbers = configuration.exactNumbers
+	return this
+}
+
+// checkStrict decodes input into a generic tree using serializer, and reports
+// every key in it which obj has no field for, as named by the given tag.
+func checkStrict(serializer Serializer, input []byte, obj interface{}, tag string) error {
+	var tree interface{}
+	if err := serializer.Deserialize(input, &tree); err != nil {
+		return err
+	}
+	return checkUnknownKeys(tree, obj, tag, KeyNamingDefault)
+}
+
+// decodeYAMLDocuments returns the root node of every non-empty document in
+// a YAML stream.
+func decodeYAMLDocuments(input []byte) ([]*yaml.Node, error) {
+	var documents []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(input))
+
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(document.Content) > 0 {
+			documents = append(documents, document.Content[0])
+		}
+	}
+}
+
+// mergeYAMLNodes deep merges override into base. Mappings are merged key by
+// key and any other kind of node replaces what it overrides.
+func mergeYAMLNodes(base, override *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		return override
+	}
+
+	merged := *base
+	merged.Content = append([]*yaml.Node(nil), base.Content...)
+
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i], override.Content[i+1]
+		replaced := false
+		for j := 0; j+1 < len(merged.Content); j += 2 {
+			if merged.Content[j].Value == key.Value {
+				merged.Content[j+1] = mergeYAMLNodes(merged.Content[j+1], value)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged.Content = append(merged.Content, key, value)
+		}
+	}
+
+	return &merged
+}
+
+// yamlDocumentMatches reports whether document is a mapping whose top-level
+// key has the given scalar value.
+func yamlDocumentMatches(document *yaml.Node, key, value string) bool {
+	if document.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		if document.Content[i].Value == key {
+			candidate := document.Content[i+1]
+			return candidate.Kind == yaml.ScalarNode && candidate.Value == value
+		}
+	}
+	return false
 }
 
 func (this XMLSerializer) Serialize(input interface{}) ([]byte, error) {
@@ -75,9 +347,89 @@ func (this XMLSerializer) Serialize(input interface{}) ([]byte, error) {
 }
 
 func (this XMLSerializer) Deserialize(input []byte, obj interface{}) error {
+	if this.Strict {
+		if err := checkStrict(XMLSerializer{}, input, obj, "xml"); err != nil {
+			return err
+		}
+	}
+
+	switch target := obj.(type) {
+	case *map[string]interface{}:
+		tree, err := decodeXMLTree(input)
+		if err != nil {
+			return err
+		}
+		if data, ok := tree.(map[string]interface{}); ok {
+			*target = data
+			return nil
+		}
+		return fmt.Errorf("XML root element has no child elements to decode into a map")
+	case *interface{}:
+		tree, err := decodeXMLTree(input)
+		if err != nil {
+			return err
+		}
+		*target = tree
+		return nil
+	}
 	return xml.Unmarshal(input, &obj)
 }
 
+// decodeXMLTree converts the content of an XML document's root element into a
+// generic tree. Child elements and attributes become map keys, repeated
+// elements become lists, and elements with only text become strings.
+func decodeXMLTree(input []byte) (interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(input))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return decodeXMLElement(decoder, start)
+		}
+	}
+}
+
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	children := make(map[string]interface{})
+	for _, attribute := range start.Attr {
+		children[attribute.Name.Local] = attribute.Value
+	}
+
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch existing := children[name].(type) {
+			case nil:
+				children[name] = child
+			case []interface{}:
+				children[name] = append(existing, child)
+			default:
+				children[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(children) == 0 {
+				return strings.TrimSpace(text.String()), nil
+			}
+			return children, nil
+		}
+	}
+}
+
 func (this INISerializer) Serialize(input interface{}) ([]byte, error) {
 	cfg := ini.Empty()
 	v := reflect.ValueOf(input)
@@ -114,15 +466,71 @@ func (this INISerializer) Deserialize(input []byte, obj interface{}) error {
 	if err != nil {
 		return err
 	}
+
+	if this.Strict {
+		if err := checkUnknownKeys(decodeINITree(cfg), obj, "ini", KeyNamingDefault); err != nil {
+			return err
+		}
+	}
+
+	switch target := obj.(type) {
+	case *map[string]interface{}:
+		*target = decodeINITree(cfg)
+		return nil
+	case *interface{}:
+		*target = decodeINITree(cfg)
+		return nil
+	}
 	return cfg.MapTo(obj)
 }
 
+// decodeINITree converts INI content into a generic tree. Keys in the default
+// section are top-level keys, and every other section becomes a nested map.
+func decodeINITree(cfg *ini.File) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, section := range cfg.Sections() {
+		values := result
+		if section.Name() != ini.DefaultSection {
+			values = make(map[string]interface{})
+			result[section.Name()] = values
+		}
+		for _, key := range section.Keys() {
+			values[key.Name()] = key.Value()
+		}
+	}
+	return result
+}
+
 func (this JSONSerializer) Serialize(input interface{}) ([]byte, error) {
 	return json5.Marshal(input)
 }
 
 func (this JSONSerializer) Deserialize(input []byte, obj interface{}) error {
-	return json5.Unmarshal(input, obj)
+	if this.Strict {
+		if err := checkStrict(JSONSerializer{}, input, obj, "json"); err != nil {
+			return err
+		}
+	}
+
+	switch obj.(type) {
+	case *map[string]interface{}, *interface{}:
+		// Decode numbers from their text, so that integers aren't read
+		// as float64 and then normalized back without their precision
+		decoder := json5.NewDecoder(bytes.NewReader(input))
+		decoder.UseNumber()
+		var trailing interface{}
+		if decoder.Decode(obj) == nil && errors.Is(decoder.Decode(&trailing), io.EOF) {
+			normalizeGeneric(obj, this.ExactNumbers)
+			return nil
+		}
+	}
+
+	// Unmarshal reports errors, including trailing content, by offset
+	if err := json5.Unmarshal(input, obj); err != nil {
+		return err
+	}
+	normalizeGeneric(obj, this.ExactNumbers)
+	return nil
 }
 
 func (this TOMLSerializer) Serialize(input interface{}) ([]byte, error) {
@@ -130,7 +538,150 @@ func (this TOMLSerializer) Serialize(input interface{}) ([]byte, error) {
 }
 
 func (this TOMLSerializer) Deserialize(input []byte, obj interface{}) error {
-	return toml.Unmarshal(input, obj)
+	decoder := toml.NewDecoder(bytes.NewReader(input))
+	if this.Strict {
+		if err := checkStrict(TOMLSerializer{}, input, obj, "toml"); err != nil {
+			return err
+		}
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(obj); err != nil {
+		return err
+	}
+	normalizeGeneric(obj, false)
+	return nil
+}
+
+func (this CBORSerializer) Serialize(input interface{}) ([]byte, error) {
+	return cbor.Marshal(input)
+}
+
+func (this CBORSerializer) Deserialize(input []byte, obj interface{}) error {
+	if err := cbor.Unmarshal(input, obj); err != nil {
+		return err
+	}
+	normalizeGeneric(obj, this.ExactNumbers)
+	return nil
+}
+
+func (this MsgPackSerializer) Serialize(input interface{}) ([]byte, error) {
+	return msgpack.Marshal(input)
+}
+
+func (this MsgPackSerializer) Deserialize(input []byte, obj interface{}) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(input))
+	decoder.UseLooseInterfaceDecoding(true)
+	if err := decoder.Decode(obj); err != nil {
+		return err
+	}
+	normalizeGeneric(obj, this.ExactNumbers)
+	return nil
+}
+
+// sniffSerializer chooses a binary serializer from the leading bytes of
+// content, for identifiers which have no recognized extension. Only documents
+// which start with a map are recognized, since configurations are maps.
+func sniffSerializer(content []byte) (SerializerFactory, bool) {
+	if len(content) == 0 {
+		return nil, false
+	}
+
+	// CBOR self-described tag 55799
+	if bytes.HasPrefix(content, []byte{0xd9, 0xd9, 0xf7}) {
+		return NewCBORSerializer, true
+	}
+
+	switch first := content[0]; {
+	case first >= 0xa0 && first <= 0xbf:
+		// CBOR map (major type 5)
+		return NewCBORSerializer, true
+	case first >= 0x80 && first <= 0x8f, first == 0xde, first == 0xdf:
+		// MessagePack fixmap, map16 and map32
+		return NewMsgPackSerializer, true
+	}
+
+	return nil, false
+}
+
+func (this *JsonnetSerializer) Serialize(input interface{}) ([]byte, error) {
+	// Any JSON document is also valid Jsonnet
+	return JSONSerializer{}.Serialize(input)
+}
+
+func (this *JsonnetSerializer) Deserialize(input []byte, obj interface{}) error {
+	vm := jsonnet.MakeVM()
+	importer := &recordingImporter{
+		importer: &jsonnet.FileImporter{JPaths: this.JPaths},
+		filename: this.Filename,
+		content:  jsonnet.MakeContentsRaw(input),
+	}
+	vm.Importer(importer)
+
+	for key, value := range this.ExtVars {
+		vm.ExtVar(key, value)
+	}
+	for key, value := range this.ExtCode {
+		vm.ExtCode(key, value)
+	}
+
+	var output string
+	var err error
+	if this.Filename == "" {
+		output, err = vm.EvaluateAnonymousSnippet("<snippet>", string(input))
+	} else {
+		output, err = vm.EvaluateFile(this.Filename)
+	}
+	if err != nil {
+		return err
+	}
+
+	this.dependencies = importer.imported
+	return JSONSerializer{Strict: this.Strict, ExactNumbers: this.ExactNumbers}.Deserialize([]byte(output), obj)
+}
+
+// Dependencies returns the files imported during the last call to Deserialize.
+func (this *JsonnetSerializer) Dependencies() []string {
+	dependencies := make([]string, len(this.dependencies))
+	copy(dependencies, this.dependencies)
+	return dependencies
+}
+
+func (this *JsonnetSerializer) configure(identifier string, configuration *Configuration) Serializer {
+	this.Filename = identifier
+	this.ExtVars = configuration.jsonnetExtVars
+	this.ExtCode = configuration.jsonnetExtCode
+	this.Strict = configuration.strict
+	this.ExactNumbers = configuration.exactNumbers
+	return this
+}
+
+// recordingImporter serves the root document from memory, so that content from
+// any Loader can be evaluated, and records every other file that is imported.
+type recordingImporter struct {
+	importer jsonnet.Importer
+	filename string
+	content  jsonnet.Contents
+	imported []string
+}
+
+func (this *recordingImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	if importedFrom == "" && importedPath == this.filename {
+		return this.content, this.filename, nil
+	}
+
+	contents, foundAt, err := this.importer.Import(importedFrom, importedPath)
+	if err != nil {
+		return contents, foundAt, err
+	}
+
+	for _, existing := range this.imported {
+		if existing == foundAt {
+			return contents, foundAt, nil
+		}
+	}
+	this.imported = append(this.imported, foundAt)
+	return contents, foundAt, nil
 }
 
 func init() {
@@ -143,4 +694,8 @@ func init() {
 	defaultSerializers[".xml"] = NewXMLSerializer
 	defaultSerializers[".ini"] = NewINISerializer
 	defaultSerializers[".toml"] = NewTOMLSerializer
+	defaultSerializers[".cbor"] = NewCBORSerializer
+	defaultSerializers[".msgpack"] = NewMsgPackSerializer
+	defaultSerializers[".jsonnet"] = NewJsonnetSerializer
+	defaultSerializers[".libsonnet"] = NewJsonnetSerializer
 }
@@ -1,6 +1,9 @@
 package prefer
 
 import (
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -205,3 +208,323 @@ func TestINISerializerDeserializeInvalidINI(t *testing.T) {
 		t.Error("Expected error for invalid INI content")
 	}
 }
+
+func TestNewSerializerReturnsJsonnetSerializer(t *testing.T) {
+	for _, identifier := range []string{"example.jsonnet", "example.libsonnet"} {
+		serializer, err := NewSerializer(identifier, nil)
+		checkTestError(t, err)
+
+		if _, ok := serializer.(*JsonnetSerializer); !ok {
+			t.Error("Got Serializer of wrong type when requesting JsonnetSerializer for", identifier)
+		}
+	}
+}
+
+func TestJsonnetSerializer(t *testing.T) {
+	serializer := &JsonnetSerializer{}
+	serialized := getMockSubjectSerialize(t, serializer)
+
+	result := MockSubject{}
+	checkTestError(t, serializer.Deserialize(serialized, &result))
+
+	if result != getMockSubject() {
+		t.Error("Result does not match original serialized object.")
+	}
+}
+
+func TestJsonnetSerializerEvaluatesExpressions(t *testing.T) {
+	serializer := &JsonnetSerializer{
+		ExtVars: map[string]string{"name": "Mock"},
+		ExtCode: map[string]string{"base": "10"},
+	}
+
+	content := []byte(`
+		// Comments and locals are evaluated before deserializing
+		local value = std.extVar("base") * 3;
+		{ Name: std.extVar("name") + " Name", Value: value }
+	`)
+
+	result := MockSubject{}
+	checkTestError(t, serializer.Deserialize(content, &result))
+
+	if result != getMockSubject() {
+		t.Error("Result does not match evaluated Jsonnet:", result)
+	}
+}
+
+func TestJsonnetSerializerRecordsImports(t *testing.T) {
+	tmpDir := t.TempDir()
+	libFile := filepath.Join(tmpDir, "common.libsonnet")
+	mainFile := filepath.Join(tmpDir, "config.jsonnet")
+
+	if err := os.WriteFile(libFile, []byte(`{ Name: "Mock Name" }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serializer := &JsonnetSerializer{Filename: mainFile}
+	content := []byte(`(import "common.libsonnet") + { Value: 30 }`)
+
+	result := MockSubject{}
+	checkTestError(t, serializer.Deserialize(content, &result))
+
+	if result != getMockSubject() {
+		t.Error("Result does not match evaluated Jsonnet:", result)
+	}
+
+	dependencies := serializer.Dependencies()
+	if len(dependencies) != 1 || dependencies[0] != libFile {
+		t.Error("Expected imported file to be recorded as a dependency, got:", dependencies)
+	}
+}
+
+func TestJsonnetSerializerReturnsEvaluationErrors(t *testing.T) {
+	serializer := &JsonnetSerializer{}
+
+	result := MockSubject{}
+	if err := serializer.Deserialize([]byte(`{ Name: error "broken" }`), &result); err == nil {
+		t.Error("Expected error for failing Jsonnet evaluation")
+	}
+}
+
+func TestNewSerializerReturnsBinarySerializersByExtension(t *testing.T) {
+	serializer, err := NewSerializer("example.cbor", nil)
+	checkTestError(t, err)
+	if reflect.TypeOf(serializer).Name() != "CBORSerializer" {
+		t.Error("Got Serializer of wrong type when requesting CBORSerializer.")
+	}
+
+	serializer, err = NewSerializer("example.msgpack", nil)
+	checkTestError(t, err)
+	if reflect.TypeOf(serializer).Name() != "MsgPackSerializer" {
+		t.Error("Got Serializer of wrong type when requesting MsgPackSerializer.")
+	}
+}
+
+func TestNewSerializerSniffsBinaryFormats(t *testing.T) {
+	data := map[string]interface{}{"name": "test"}
+
+	cborContent, err := CBORSerializer{}.Serialize(data)
+	checkTestError(t, err)
+	serializer, err := NewSerializer("config", cborContent)
+	checkTestError(t, err)
+	if reflect.TypeOf(serializer).Name() != "CBORSerializer" {
+		t.Error("Expected CBOR content to be sniffed as CBORSerializer.")
+	}
+
+	selfDescribed := append([]byte{0xd9, 0xd9, 0xf7}, cborContent...)
+	serializer, err = NewSerializer("config", selfDescribed)
+	checkTestError(t, err)
+	if reflect.TypeOf(serializer).Name() != "CBORSerializer" {
+		t.Error("Expected self-described CBOR content to be sniffed as CBORSerializer.")
+	}
+
+	msgpackContent, err := MsgPackSerializer{}.Serialize(data)
+	checkTestError(t, err)
+	serializer, err = NewSerializer("config", msgpackContent)
+	checkTestError(t, err)
+	if reflect.TypeOf(serializer).Name() != "MsgPackSerializer" {
+		t.Error("Expected MessagePack content to be sniffed as MsgPackSerializer.")
+	}
+}
+
+func TestCBORSerializer(t *testing.T) {
+	serializer := CBORSerializer{}
+	serialized := getMockSubjectSerialize(t, serializer)
+
+	result := MockSubject{}
+	checkTestError(t, serializer.Deserialize(serialized, &result))
+
+	if result != getMockSubject() {
+		t.Error("Result does not match original serialized object.")
+	}
+}
+
+func TestMsgPackSerializer(t *testing.T) {
+	serializer := MsgPackSerializer{}
+	serialized := getMockSubjectSerialize(t, serializer)
+
+	result := MockSubject{}
+	checkTestError(t, serializer.Deserialize(serialized, &result))
+
+	if result != getMockSubject() {
+		t.Error("Result does not match original serialized object.")
+	}
+}
+
+func TestBinarySerializersNormalizeGenericMaps(t *testing.T) {
+	input := map[string]interface{}{
+		"port":  uint16(8080),
+		"ratio": float32(0.5),
+		"big":   uint64(math.MaxUint64),
+		"database": map[string]interface{}{
+			"replicas": []interface{}{int8(1), uint32(2)},
+		},
+	}
+
+	for _, serializer := range []Serializer{CBORSerializer{}, MsgPackSerializer{}} {
+		name := reflect.TypeOf(serializer).Name()
+		serialized, err := serializer.Serialize(input)
+		checkTestError(t, err)
+
+		result := map[string]interface{}{}
+		checkTestError(t, serializer.Deserialize(serialized, &result))
+
+		if result["port"] != int64(8080) {
+			t.Errorf("%s: expected port to be int64(8080), got %T(%v)", name, result["port"], result["port"])
+		}
+		if result["ratio"] != float64(0.5) {
+			t.Errorf("%s: expected ratio to be float64(0.5), got %T(%v)", name, result["ratio"], result["ratio"])
+		}
+		if result["big"] != uint64(math.MaxUint64) {
+			t.Errorf("%s: expected big to keep its uint64 value, got %T(%v)", name, result["big"], result["big"])
+		}
+
+		database, ok := result["database"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: expected nested map with string keys, got %T", name, result["database"])
+		}
+		replicas, ok := database["replicas"].([]interface{})
+		if !ok || len(replicas) != 2 || replicas[0] != int64(1) || replicas[1] != int64(2) {
+			t.Errorf("%s: unexpected replicas: %#v", name, database["replicas"])
+		}
+	}
+}
+
+func TestLoadMapFromBinaryFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	content, err := MsgPackSerializer{}.Serialize(map[string]interface{}{
+		"server": map[string]interface{}{"port": 9090},
+	})
+	checkTestError(t, err)
+
+	tmpFile := filepath.Join(tmpDir, "config.msgpack")
+	if err := os.WriteFile(tmpFile, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := LoadMap(filepath.Join(tmpDir, "config"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	port, ok := cm.GetInt("server.port")
+	if !ok || port != 9090 {
+		t.Error("Expected server.port to be 9090, got:", port)
+	}
+}
+
+const multiDocumentYAML = `env: development
+database:
+  host: localhost
+  port: 5432
+---
+env: production
+database:
+  host: db.example.com
+`
+
+func TestYAMLSerializerReadsFirstDocumentByDefault(t *testing.T) {
+	result := map[string]interface{}{}
+	checkTestError(t, YAMLSerializer{}.Deserialize([]byte(multiDocumentYAML), &result))
+
+	if result["env"] != "development" {
+		t.Error("Expected first document to be read, got:", result["env"])
+	}
+}
+
+func TestYAMLSerializerReadsAllDocuments(t *testing.T) {
+	serializer := YAMLSerializer{Documents: YAMLAllDocuments}
+
+	var result []map[string]interface{}
+	checkTestError(t, serializer.Deserialize([]byte(multiDocumentYAML), &result))
+
+	if len(result) != 2 {
+		t.Fatal("Expected 2 documents, got:", len(result))
+	}
+	if result[0]["env"] != "development" || result[1]["env"] != "production" {
+		t.Error("Documents were not read in order:", result)
+	}
+}
+
+func TestYAMLSerializerMergesDocuments(t *testing.T) {
+	type Config struct {
+		Env      string `yaml:"env"`
+		Database struct {
+			Host string `yaml:"host"`
+			Port int    `yaml:"port"`
+		} `yaml:"database"`
+	}
+
+	serializer := YAMLSerializer{Documents: YAMLMergeDocuments}
+
+	var result Config
+	checkTestError(t, serializer.Deserialize([]byte(multiDocumentYAML), &result))
+
+	if result.Env != "production" || result.Database.Host != "db.example.com" {
+		t.Error("Expected later document to override earlier one:", result)
+	}
+	if result.Database.Port != 5432 {
+		t.Error("Expected port to be preserved from earlier document:", result.Database.Port)
+	}
+}
+
+func TestYAMLSerializerSelectsDocument(t *testing.T) {
+	serializer := YAMLSerializer{Documents: YAMLSelectDocument, SelectKey: "env", SelectValue: "production"}
+
+	result := map[string]interface{}{}
+	checkTestError(t, serializer.Deserialize([]byte(multiDocumentYAML), &result))
+
+	database := result["database"].(map[string]interface{})
+	if database["host"] != "db.example.com" {
+		t.Error("Expected production document to be selected, got:", result)
+	}
+	if _, ok := database["port"]; ok {
+		t.Error("Expected only the selected document to be read")
+	}
+
+	serializer.SelectValue = "staging"
+	if err := serializer.Deserialize([]byte(multiDocumentYAML), &result); err == nil {
+		t.Error("Expected error when no document matches")
+	}
+}
+
+func TestYAMLSerializerReturnsErrorForInvalidLaterDocument(t *testing.T) {
+	serializer := YAMLSerializer{Documents: YAMLMergeDocuments}
+	content := []byte("name: first\n---\nname: [unclosed\n")
+
+	result := map[string]interface{}{}
+	if err := serializer.Deserialize(content, &result); err == nil {
+		t.Error("Expected error for invalid later document")
+	}
+}
+
+func TestINISerializerDeserializesIntoMap(t *testing.T) {
+	content := []byte("name = app\n[database]\nhost = localhost\n")
+
+	result := map[string]interface{}{}
+	checkTestError(t, INISerializer{}.Deserialize(content, &result))
+
+	database, ok := result["database"].(map[string]interface{})
+	if result["name"] != "app" || !ok || database["host"] != "localhost" {
+		t.Error("Unexpected INI tree:", result)
+	}
+}
+
+func TestXMLSerializerDeserializesIntoMap(t *testing.T) {
+	content := []byte(`<config env="dev"><name>app</name><server>a</server><server>b</server><empty/></config>`)
+
+	result := map[string]interface{}{}
+	checkTestError(t, XMLSerializer{}.Deserialize(content, &result))
+
+	servers, ok := result["server"].([]interface{})
+	if result["env"] != "dev" || result["name"] != "app" || result["empty"] != "" {
+		t.Error("Unexpected XML tree:", result)
+	}
+	if !ok || len(servers) != 2 || servers[1] != "b" {
+		t.Error("Expected repeated elements to become a list, got:", result["server"])
+	}
+
+	if err := (XMLSerializer{}).Deserialize([]byte(`<config>text</config>`), &result); err == nil {
+		t.Error("Expected error when root element has no children")
+	}
+}
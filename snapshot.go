@@ -0,0 +1,150 @@
+package prefer
+
+import (
+	"fmt"
+)
+
+// mapState is a version of a ConfigMap's data. It is never modified once it
+// has been stored, so readers can use it without locking.
+type mapState struct {
+	data map[string]interface{}
+}
+
+// load returns the root map's current data.
+func (c *ConfigMap) load() map[string]interface{} {
+	if state := c.state.Load(); state != nil {
+		return state.data
+	}
+	return nil
+}
+
+// current returns the value at the map's prefix in the current data, which
+// is the whole of the data for the root map.
+func (c *ConfigMap) current() interface{} {
+	value, _ := c.lookup("")
+	return value
+}
+
+// update replaces the root map's data with the result of change, which must
+// not modify the data it is given, and notifies watchers of path.
+func (c *ConfigMap) update(path []pathSegment, change func(map[string]interface{}) (map[string]interface{}, error)) error {
+	root := c.root()
+
+	root.updateMutex.Lock()
+	if root.frozen.Load() {
+		root.updateMutex.Unlock()
+		return ErrFrozen
+	}
+
+	data := root.load()
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	updated, err := change(data)
+	if err == nil {
+		root.state.Store(&mapState{data: updated})
+	}
+	root.updateMutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+	root.notify(path)
+	return nil
+}
+
+// Replace atomically replaces the map's contents with data, as when a
+// configuration is reloaded, so that readers see either the old contents or
+// the new ones and never a mixture. For views returned by Sub, the value at
+// the view's prefix is replaced. A copy of data is normalized as it is by
+// NewConfigMap, leaving data itself unchanged.
+func (c *ConfigMap) Replace(data map[string]interface{}) error {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data = normalizeMap(copyTree(data).(map[string]interface{}), true)
+
+	segments, err := c.resolve("")
+	if err != nil {
+		return err
+	}
+
+	err = c.update(segments, func(current map[string]interface{}) (map[string]interface{}, error) {
+		if len(segments) == 0 {
+			return data, nil
+		}
+		updated, err := setPath(current, segments, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		return updated.(map[string]interface{}), nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot replace %s: %w", describePath(segments), err)
+	}
+	return nil
+}
+
+// Snapshot returns a frozen copy of the map as it is now, which later
+// changes to the map don't affect. For views returned by Sub, the snapshot
+// contains only the map at the view's prefix. Taking a snapshot doesn't copy
+// any data.
+func (c *ConfigMap) Snapshot() *ConfigMap {
+	data, ok := c.current().(map[string]interface{})
+	if !ok {
+		data = make(map[string]interface{})
+	}
+	snapshot := newConfigMap(data)
+	snapshot.frozen.Store(true)
+	if c.parent == nil {
+		snapshot.origins = c.origins
+	}
+	return snapshot
+}
+
+// Freeze makes the map read-only, so that Set and Replace fail with
+// ErrFrozen and Delete returns false, such as once an application has
+// finished starting up. Freezing a view returned by Sub freezes the whole of
+// the map it belongs to.
+func (c *ConfigMap) Freeze() {
+	root := c.root()
+	root.updateMutex.Lock()
+	root.frozen.Store(true)
+	root.updateMutex.Unlock()
+}
+
+// Frozen reports whether the map is read-only.
+func (c *ConfigMap) Frozen() bool {
+	return c.root().frozen.Load()
+}
+
+// copyTree returns a deep copy of the maps and lists in value.
+func copyTree(value interface{}) interface{} {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(node))
+		for key, item := range node {
+			result[key] = copyTree(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(node))
+		for index, item := range node {
+			result[index] = copyTree(item)
+		}
+		return result
+	case map[interface{}]interface{}:
+		result := make(map[interface{}]interface{}, len(node))
+		for key, item := range node {
+			result[key] = copyTree(item)
+		}
+		return result
+	case []map[string]interface{}:
+		result := make([]map[string]interface{}, len(node))
+		for index, item := range node {
+			result[index] = copyTree(item).(map[string]interface{})
+		}
+		return result
+	}
+	return value
+}
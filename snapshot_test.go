@@ -0,0 +1,201 @@
+package prefer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConfigMapConcurrentAccess(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{
+		"server": map[string]interface{}{"port": 8080},
+	})
+
+	var wait sync.WaitGroup
+	for writer := 0; writer < 4; writer++ {
+		wait.Add(1)
+		go func(writer int) {
+			defer wait.Done()
+			for index := 0; index < 100; index++ {
+				checkTestError(t, config.Set(fmt.Sprintf("writers.%d.count", writer), index))
+				checkTestError(t, config.Set("servers[]", index))
+			}
+		}(writer)
+	}
+	for reader := 0; reader < 4; reader++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for index := 0; index < 100; index++ {
+				config.GetInt("server.port")
+				config.AllKeys()
+				config.Sub("writers").Data()
+			}
+		}()
+	}
+	wait.Wait()
+
+	if servers, _ := config.GetSlice("servers"); len(servers) != 400 {
+		t.Errorf("Expected every append to be kept, got %d", len(servers))
+	}
+}
+
+func TestConfigMapSnapshot(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{
+		"database": map[string]interface{}{"host": "localhost"},
+		"servers":  []interface{}{"a"},
+	})
+
+	snapshot := config.Snapshot()
+	database := config.Sub("database").Snapshot()
+
+	checkTestError(t, config.Set("database.host", "db.internal"))
+	checkTestError(t, config.Set("servers[]", "b"))
+	config.Delete("database.host")
+
+	if host, _ := snapshot.GetString("database.host"); host != "localhost" {
+		t.Error("Expected the snapshot to be unaffected by later changes")
+	}
+	if servers, _ := snapshot.GetSlice("servers"); len(servers) != 1 {
+		t.Error("Expected the snapshot's lists to be unaffected by later appends")
+	}
+	if host, _ := database.GetString("host"); host != "localhost" {
+		t.Error("Expected a view's snapshot to contain the map at its prefix")
+	}
+
+	if !snapshot.Frozen() {
+		t.Error("Expected snapshots to be frozen")
+	}
+	if err := snapshot.Set("database.host", "x"); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Expected ErrFrozen setting a snapshot, got %v", err)
+	}
+}
+
+func TestConfigMapFreeze(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{"name": "app"})
+	config.Sub("anything").Freeze()
+
+	if !config.Frozen() {
+		t.Error("Expected freezing a view to freeze its map")
+	}
+	if err := config.Set("name", "other"); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+	if config.Delete("name") || !config.Has("name") {
+		t.Error("Expected Delete to return false and remove nothing once frozen")
+	}
+	if err := config.Replace(nil); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Expected ErrFrozen replacing, got %v", err)
+	}
+	if name, _ := config.GetString("name"); name != "app" {
+		t.Error("Expected a frozen map to remain readable")
+	}
+}
+
+func TestConfigMapReplace(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{"name": "app", "port": 8080})
+	done := make(chan struct{})
+	defer close(done)
+	changes := config.Sub("name").Watch(done)
+
+	checkTestError(t, config.Replace(map[string]interface{}{"name": "reloaded"}))
+	if name, _ := config.GetString("name"); name != "reloaded" || config.Has("port") {
+		t.Errorf("Expected the contents to be replaced, got %v", config.Data())
+	}
+	select {
+	case <-changes:
+	default:
+		t.Error("Expected watchers to be notified of the replacement")
+	}
+
+	checkTestError(t, config.Sub("database").Replace(map[string]interface{}{"host": "localhost"}))
+	if host, _ := config.GetString("database.host"); host != "localhost" {
+		t.Error("Expected replacing a view to replace the map at its prefix")
+	}
+}
+
+func TestConfigMapDataIsACopy(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{
+		"database": map[string]interface{}{"host": "localhost"},
+	})
+
+	data := config.Data()
+	data["database"].(map[string]interface{})["host"] = "changed"
+
+	if host, _ := config.GetString("database.host"); host != "localhost" {
+		t.Error("Expected changes to the result of Data not to affect the map")
+	}
+}
+
+func TestConfigMapAccessorsReturnCopies(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{
+		"db":      map[string]interface{}{"host": "localhost"},
+		"servers": []interface{}{map[string]interface{}{"host": "a"}},
+	})
+	config.Freeze()
+	snapshot := config.Snapshot()
+
+	db, _ := config.GetMap("db")
+	db["host"] = "evil"
+	servers, _ := config.GetSlice("servers")
+	servers[0].(map[string]interface{})["host"] = "evil"
+	value, _ := config.Get("db")
+	value.(map[string]interface{})["host"] = "evil"
+	results, err := config.Query("$.servers[*]")
+	checkTestError(t, err)
+	results[0].Value.(map[string]interface{})["host"] = "evil"
+	checkTestError(t, config.Walk(func(path string, value interface{}) error {
+		if node, ok := value.(map[string]interface{}); ok && path != "" {
+			node["host"] = "evil"
+		}
+		return nil
+	}))
+
+	for _, c := range []*ConfigMap{config, snapshot} {
+		if host, _ := c.GetString("db.host"); host != "localhost" {
+			t.Errorf("Expected changes to returned maps not to affect the map, got %q", host)
+		}
+		if host, _ := c.GetString("servers[0].host"); host != "a" {
+			t.Errorf("Expected changes to returned lists not to affect the map, got %q", host)
+		}
+	}
+}
+
+func TestConfigMapSetAndReplaceCopyValues(t *testing.T) {
+	config := NewConfigMap(nil)
+	servers := []interface{}{"a"}
+	checkTestError(t, config.Set("servers", servers))
+	snapshot := config.Snapshot()
+	servers[0] = "changed"
+
+	replacement := map[string]interface{}{"db": map[string]interface{}{"port": 5432}}
+	checkTestError(t, config.Replace(replacement))
+	if _, ok := replacement["db"].(map[string]interface{})["port"].(int); !ok {
+		t.Error("Expected Replace to leave the caller's map unchanged")
+	}
+	replacement["db"].(map[string]interface{})["port"] = 1
+
+	if value, _ := snapshot.Get("servers[0]"); value != "a" {
+		t.Errorf("Expected changes to values given to Set not to affect the map, got %v", value)
+	}
+	if port, _ := config.Get("db.port"); port != int64(5432) {
+		t.Errorf("Expected changes to maps given to Replace not to affect the map, got %v", port)
+	}
+}
+
+func TestConfigMapSetNormalizesValues(t *testing.T) {
+	config := NewConfigMap(nil)
+	checkTestError(t, config.Set("n", 5))
+	checkTestError(t, config.Set("a", map[interface{}]interface{}{"b": 1}))
+
+	if n, _ := config.Get("n"); n != int64(5) {
+		t.Errorf("Expected integers to be stored as int64, got %T", n)
+	}
+	if b, _ := config.Get("a.b"); b != int64(1) {
+		t.Errorf("Expected nested maps to be normalized, got %v", config.Data())
+	}
+	if changes := Diff(config, NewConfigMap(map[string]interface{}{"n": 5, "a": map[string]interface{}{"b": 1}})); len(changes) != 0 {
+		t.Errorf("Expected values set and given to NewConfigMap to compare equal, got %v", changes)
+	}
+}
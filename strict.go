@@ -0,0 +1,236 @@
+package prefer
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UnknownKey is a configuration key which doesn't correspond to any field of
+// the destination it was decoded into.
+type UnknownKey struct {
+	Path string
+	// Suggestion is the closest known key at the same level, if any is
+	// similar enough to be a likely typo.
+	Suggestion string
+}
+
+func (this UnknownKey) String() string {
+	if this.Suggestion == "" {
+		return this.Path
+	}
+	return fmt.Sprintf("%s (did you mean %q?)", this.Path, this.Suggestion)
+}
+
+// UnknownKeysError is returned in strict mode when a configuration contains
+// keys which don't correspond to any field. Every unknown key is reported.
+type UnknownKeysError struct {
+	Keys []UnknownKey
+}
+
+func (this *UnknownKeysError) Error() string {
+	descriptions := make([]string, len(this.Keys))
+	for index, key := range this.Keys {
+		descriptions[index] = key.String()
+	}
+
+	noun := "key"
+	if len(this.Keys) != 1 {
+		noun = "keys"
+	}
+	return fmt.Sprintf("unknown configuration %s: %s", noun, strings.Join(descriptions, ", "))
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// checkUnknownKeys returns an *UnknownKeysError listing every key in tree
+// which has no corresponding field in the type of dest, with fields named by
+// the given struct tag. It returns nil when every key is known.
+func checkUnknownKeys(tree interface{}, dest interface{}, tag string, naming KeyNaming) error {
+	if dest == nil {
+		return nil
+	}
+
+	var unknown []UnknownKey
+	collectUnknownKeys("", tree, reflect.TypeOf(dest), tag, naming, &unknown)
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Slice(unknown, func(i, j int) bool {
+		return unknown[i].Path < unknown[j].Path
+	})
+	return &UnknownKeysError{Keys: unknown}
+}
+
+func collectUnknownKeys(path string, tree interface{}, t reflect.Type, tag string, naming KeyNaming, unknown *[]UnknownKey) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types which decode themselves from scalars have no fields to check
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		data, ok := tree.(map[string]interface{})
+		if !ok {
+			return
+		}
+
+		fields := structFields(t, tag, naming)
+		if tag == xmlTagName {
+			collectUnknownXMLKeys(path, data, t, xmlFields(fields), naming, unknown)
+			return
+		}
+		for key, value := range data {
+			f, found := findField(fields, key)
+			if !found {
+				*unknown = append(*unknown, UnknownKey{
+					Path:       joinPath(path, key),
+					Suggestion: suggestKey(key, fields),
+				})
+				continue
+			}
+			collectUnknownKeys(joinPath(path, key), value, t.FieldByIndex(f.index).Type, tag, naming, unknown)
+		}
+	case reflect.Map:
+		data, ok := tree.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, value := range data {
+			collectUnknownKeys(joinPath(path, key), value, t.Elem(), tag, naming, unknown)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := tree.([]interface{})
+		if !ok {
+			return
+		}
+		for index, item := range items {
+			collectUnknownKeys(joinPath(path, strconv.Itoa(index)), item, t.Elem(), tag, naming, unknown)
+		}
+	}
+}
+
+// findField finds the field for key, preferring an exact match over a
+// case-insensitive one as the decoders do.
+func findField(fields []field, key string) (field, bool) {
+	for _, f := range fields {
+		if f.key == key {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.key, key) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// xmlTagName is the struct tag read by encoding/xml.
+const xmlTagName = "xml"
+
+// xmlFields returns fields with the namespaces removed from their keys, as
+// encoding/xml matches elements by their local names. Keys may still be
+// paths through nested elements, such as "a>b".
+func xmlFields(fields []field) []field {
+	result := make([]field, len(fields))
+	for index, f := range fields {
+		if space := strings.LastIndexByte(f.key, ' '); space >= 0 {
+			f.key = f.key[space+1:]
+		}
+		result[index] = f
+	}
+	return result
+}
+
+// collectUnknownXMLKeys reports the keys of data which none of fields names,
+// matching them exactly as encoding/xml does. Keys which begin the path of a
+// field such as "a>b" are followed into the element they name.
+func collectUnknownXMLKeys(path string, data map[string]interface{}, t reflect.Type, fields []field, naming KeyNaming, unknown *[]UnknownKey) {
+	for key, value := range data {
+		var nested []field
+		found := false
+		for _, f := range fields {
+			if f.key == key {
+				collectUnknownKeys(joinPath(path, key), value, t.FieldByIndex(f.index).Type, xmlTagName, naming, unknown)
+				found = true
+				break
+			}
+			if rest, ok := strings.CutPrefix(f.key, key+">"); ok {
+				f.key = rest
+				nested = append(nested, f)
+			}
+		}
+		if found {
+			continue
+		}
+
+		if element, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			collectUnknownXMLKeys(joinPath(path, key), element, t, nested, naming, unknown)
+			continue
+		}
+		if len(nested) == 0 {
+			*unknown = append(*unknown, UnknownKey{
+				Path:       joinPath(path, key),
+				Suggestion: suggestKey(key, fields),
+			})
+		}
+	}
+}
+
+// suggestKey returns the known key closest to key, if it is close enough to
+// likely be a typo.
+func suggestKey(key string, fields []field) string {
+	best, bestDistance := "", -1
+	for _, f := range fields {
+		distance := editDistance(strings.ToLower(key), strings.ToLower(f.key))
+		if bestDistance == -1 || distance < bestDistance {
+			best, bestDistance = f.key, distance
+		}
+	}
+
+	threshold := len(key) / 3
+	if threshold < 2 {
+		threshold = 2
+	}
+	if bestDistance == -1 || bestDistance > threshold {
+		return ""
+	}
+	return best
+}
+
+// editDistance returns the Damerau-Levenshtein distance between a and b,
+// counting transposed neighbors as a single edit.
+func editDistance(a, b string) int {
+	first, second := []rune(a), []rune(b)
+	rows := make([][]int, len(first)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(second)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(first); i++ {
+		for j := 1; j <= len(second); j++ {
+			cost := 1
+			if first[i-1] == second[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && first[i-1] == second[j-2] && first[i-2] == second[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(first)][len(second)]
+}
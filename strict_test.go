@@ -0,0 +1,125 @@
+package prefer
+
+import (
+	"errors"
+	"testing"
+)
+
+type strictDatabase struct {
+	Host string `yaml:"host" json:"host" toml:"host" ini:"host" xml:"host" prefer:"host"`
+	Port int    `yaml:"port" json:"port" toml:"port" ini:"port" xml:"port" prefer:"port"`
+}
+
+type strictConfig struct {
+	Name     string         `yaml:"name" json:"name" toml:"name" ini:"name" xml:"name"`
+	Database strictDatabase `yaml:"database" json:"database" toml:"database" ini:"database" xml:"database"`
+}
+
+func TestStrictSerializersReportEveryUnknownKey(t *testing.T) {
+	cases := map[Serializer]string{
+		YAMLSerializer{Strict: true}:     "name: app\nnmae: typo\ndatabse: {}\ndatabase:\n  host: db\n  prot: 1\n",
+		JSONSerializer{Strict: true}:     `{name: "app", nmae: "typo", databse: {}, database: {host: "db", prot: 1}}`,
+		TOMLSerializer{Strict: true}:     "name = \"app\"\nnmae = \"typo\"\n[databse]\n[database]\nhost = \"db\"\nprot = 1\n",
+		INISerializer{Strict: true}:      "name = app\nnmae = typo\n[databse]\nx = 1\n[database]\nhost = db\nprot = 1\n",
+		XMLSerializer{Strict: true}:      "<c><name>app</name><nmae>typo</nmae><databse/><database><host>db</host><prot>1</prot></database></c>",
+		&JsonnetSerializer{Strict: true}: `{name: "app", nmae: "typo", databse: {}, database: {host: "db", prot: 1}}`,
+	}
+
+	for serializer, content := range cases {
+		var config strictConfig
+		err := serializer.Deserialize([]byte(content), &config)
+
+		var unknown *UnknownKeysError
+		if !errors.As(err, &unknown) {
+			t.Errorf("%T: expected UnknownKeysError, got: %v", serializer, err)
+			continue
+		}
+
+		expected := []UnknownKey{
+			{Path: "database.prot", Suggestion: "port"},
+			{Path: "databse", Suggestion: "database"},
+			{Path: "nmae", Suggestion: "name"},
+		}
+		if len(unknown.Keys) != len(expected) {
+			t.Errorf("%T: expected %d unknown keys, got: %v", serializer, len(expected), unknown.Keys)
+			continue
+		}
+		for index, key := range expected {
+			if unknown.Keys[index] != key {
+				t.Errorf("%T: expected %v, got %v", serializer, key, unknown.Keys[index])
+			}
+		}
+	}
+}
+
+func TestStrictSerializersAcceptKnownKeys(t *testing.T) {
+	var config strictConfig
+	content := []byte("name: app\ndatabase:\n  host: db\n  port: 5432\n")
+	checkTestError(t, YAMLSerializer{Strict: true}.Deserialize(content, &config))
+
+	if config.Database.Port != 5432 {
+		t.Error("Expected strict decoding to still decode values, got:", config)
+	}
+
+	// Generic destinations have no unknown keys
+	result := map[string]interface{}{}
+	checkTestError(t, YAMLSerializer{Strict: true}.Deserialize([]byte("anything: 1"), &result))
+}
+
+func TestStrictXMLMatchesElementsExactly(t *testing.T) {
+	type Config struct {
+		Name string
+		Host string `xml:"server>host"`
+		Port int    `xml:"urn:example server>port"`
+	}
+	content := "<c><name>app</name><Name>app</Name><server><host>a</host><port>1</port><Host>b</Host></server></c>"
+
+	var config Config
+	err := XMLSerializer{Strict: true}.Deserialize([]byte(content), &config)
+
+	var unknown *UnknownKeysError
+	if !errors.As(err, &unknown) || len(unknown.Keys) != 2 || unknown.Keys[0].Path != "name" || unknown.Keys[1].Path != "server.Host" {
+		t.Errorf("Expected the keys encoding/xml ignores to be reported, got: %v", err)
+	}
+}
+
+func TestUnknownKeysErrorMessage(t *testing.T) {
+	err := &UnknownKeysError{Keys: []UnknownKey{
+		{Path: "databse", Suggestion: "database"},
+		{Path: "zzz"},
+	}}
+
+	expected := `unknown configuration keys: databse (did you mean "database"?), zzz`
+	if err.Error() != expected {
+		t.Error("Unexpected error message:", err.Error())
+	}
+}
+
+func TestSuggestKeyIgnoresDistantKeys(t *testing.T) {
+	fields := []field{{key: "database"}, {key: "timeout"}}
+
+	if suggestion := suggestKey("verbose", fields); suggestion != "" {
+		t.Error("Expected no suggestion for unrelated key, got:", suggestion)
+	}
+	if suggestion := suggestKey("timeuot", fields); suggestion != "timeout" {
+		t.Error("Expected transposition to be suggested, got:", suggestion)
+	}
+}
+
+func TestLoadWithStrict(t *testing.T) {
+	type Config struct {
+		Database strictDatabase `prefer:"database"`
+	}
+
+	loader := NewMemoryLoader("config.yaml", []byte("databse:\n  host: db\n"))
+
+	var config Config
+	_, err := Load("unused", &config, WithLoader(loader))
+	checkTestError(t, err)
+
+	_, err = Load("unused", &config, WithLoader(loader), WithStrict())
+	var unknown *UnknownKeysError
+	if !errors.As(err, &unknown) || unknown.Keys[0].Suggestion != "database" {
+		t.Error("Expected strict load to reject misspelled key, got:", err)
+	}
+}
@@ -0,0 +1,258 @@
+package prefer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteSize is a number of bytes which can be written with SI units such as
+// "10MB" (10 * 1000^2) or IEC units such as "10MiB" (10 * 1024^2).
+type ByteSize uint64
+
+var byteUnits = map[string]uint64{
+	"":    1,
+	"b":   1,
+	"k":   1000,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"m":   1000 * 1000,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"g":   1000 * 1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+	"t":   1000 * 1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1 << 40,
+	"p":   1000 * 1000 * 1000 * 1000 * 1000,
+	"pb":  1000 * 1000 * 1000 * 1000 * 1000,
+	"pib": 1 << 50,
+	"e":   1000 * 1000 * 1000 * 1000 * 1000 * 1000,
+	"eb":  1000 * 1000 * 1000 * 1000 * 1000 * 1000,
+	"eib": 1 << 60,
+}
+
+// ParseByteSize parses sizes such as "512", "1.5GB" or "10 MiB". Units are
+// case-insensitive, and the result must be a whole number of bytes.
+func ParseByteSize(text string) (ByteSize, error) {
+	text = strings.TrimSpace(text)
+	split := strings.IndexFunc(text, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.')
+	})
+	if split == -1 {
+		split = len(text)
+	}
+
+	number := text[:split]
+	multiplier, ok := byteUnits[strings.ToLower(strings.TrimSpace(text[split:]))]
+	if number == "" || !ok {
+		return 0, fmt.Errorf("invalid byte size %q", text)
+	}
+
+	if whole, err := strconv.ParseUint(number, 10, 64); err == nil {
+		high, low := bits.Mul64(whole, multiplier)
+		if high != 0 {
+			return 0, fmt.Errorf("byte size %q overflows", text)
+		}
+		return ByteSize(low), nil
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte size %q", text)
+	}
+	size := value * float64(multiplier)
+	if size >= math.MaxUint64 {
+		return 0, fmt.Errorf("byte size %q overflows", text)
+	}
+	if size != math.Trunc(size) {
+		return 0, fmt.Errorf("byte size %q is not a whole number of bytes", text)
+	}
+	return ByteSize(size), nil
+}
+
+// String formats the size with the largest IEC or SI unit which divides it
+// exactly, such as "10MiB" or "1500B".
+func (this ByteSize) String() string {
+	if this == 0 {
+		return "0B"
+	}
+
+	iec := []string{"EiB", "PiB", "TiB", "GiB", "MiB", "KiB"}
+	for index, unit := range iec {
+		multiplier := uint64(1) << (10 * (len(iec) - index))
+		if uint64(this)%multiplier == 0 {
+			return strconv.FormatUint(uint64(this)/multiplier, 10) + unit
+		}
+	}
+
+	si := []string{"EB", "PB", "TB", "GB", "MB", "KB"}
+	for index, unit := range si {
+		multiplier := uint64(math.Pow10(3 * (len(si) - index)))
+		if uint64(this)%multiplier == 0 {
+			return strconv.FormatUint(uint64(this)/multiplier, 10) + unit
+		}
+	}
+
+	return strconv.FormatUint(uint64(this), 10) + "B"
+}
+
+func (this ByteSize) MarshalText() ([]byte, error) {
+	return []byte(this.String()), nil
+}
+
+func (this *ByteSize) UnmarshalText(text []byte) error {
+	size, err := ParseByteSize(string(text))
+	if err != nil {
+		return err
+	}
+	*this = size
+	return nil
+}
+
+// timeLayouts are tried in order when decoding strings into time.Time.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+var (
+	urlType    = reflect.TypeOf(url.URL{})
+	ipNetType  = reflect.TypeOf(net.IPNet{})
+	timeType   = reflect.TypeOf(time.Time{})
+	bigIntType = reflect.TypeOf(big.Int{})
+	numberType = reflect.TypeOf(json.Number(""))
+)
+
+// decodeText decodes strings into standard library types which don't
+// implement encoding.TextUnmarshaler, or which accept more than one layout.
+// It reports whether out had such a type.
+func decodeText(text string, out reflect.Value) (bool, error) {
+	text = strings.TrimSpace(text)
+
+	switch out.Type() {
+	case urlType:
+		parsed, err := url.Parse(text)
+		if err != nil {
+			return true, err
+		}
+		out.Set(reflect.ValueOf(*parsed))
+		return true, nil
+	case ipNetType:
+		_, network, err := net.ParseCIDR(text)
+		if err != nil {
+			return true, err
+		}
+		out.Set(reflect.ValueOf(*network))
+		return true, nil
+	case timeType:
+		for _, layout := range timeLayouts {
+			if parsed, err := time.Parse(layout, text); err == nil {
+				out.Set(reflect.ValueOf(parsed))
+				return true, nil
+			}
+		}
+		return true, fmt.Errorf("cannot parse %q as a time", text)
+	}
+
+	return false, nil
+}
+
+// decodeNumber decodes numbers into big.Int and json.Number, which hold
+// values exactly as WithExactNumbers produces them. It reports whether out
+// had such a type.
+func decodeNumber(input interface{}, out reflect.Value) (bool, error) {
+	switch out.Type() {
+	case bigIntType:
+		integer, err := toBigInt(input)
+		if err != nil {
+			return true, err
+		}
+		out.Set(reflect.ValueOf(integer).Elem())
+		return true, nil
+	case numberType:
+		text, err := numberText(input)
+		if err != nil {
+			return true, err
+		}
+		out.SetString(text)
+		return true, nil
+	}
+	return false, nil
+}
+
+func toBigInt(input interface{}) (*big.Int, error) {
+	switch value := input.(type) {
+	case *big.Int:
+		return new(big.Int).Set(value), nil
+	case string:
+		if integer, ok := new(big.Int).SetString(strings.TrimSpace(value), 0); ok {
+			return integer, nil
+		}
+		return nil, fmt.Errorf("%q is not an integer", value)
+	case json.Number:
+		return toBigInt(string(value))
+	case float64:
+		if math.IsInf(value, 0) || math.IsNaN(value) || value != math.Trunc(value) {
+			return nil, fmt.Errorf("%g is not an integer", value)
+		}
+		integer, _ := big.NewFloat(value).Int(nil)
+		return integer, nil
+	}
+
+	inputValue := reflect.ValueOf(input)
+	switch inputValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(inputValue.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Int).SetUint64(inputValue.Uint()), nil
+	}
+	return nil, fmt.Errorf("unsupported conversion")
+}
+
+func numberText(input interface{}) (string, error) {
+	switch value := input.(type) {
+	case json.Number:
+		return string(value), nil
+	case *big.Int:
+		return value.String(), nil
+	case string:
+		text := strings.TrimSpace(value)
+		if _, ok := new(big.Float).SetString(text); !ok {
+			return "", fmt.Errorf("%q is not a number", value)
+		}
+		return text, nil
+	}
+
+	inputValue := reflect.ValueOf(input)
+	switch inputValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(inputValue.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(inputValue.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(inputValue.Float(), 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported conversion")
+}
+
+// bigIntFloat converts integer to the nearest float64, failing when it is
+// out of range rather than returning an infinity.
+func bigIntFloat(integer *big.Int) (float64, error) {
+	result, _ := new(big.Float).SetInt(integer).Float64()
+	if math.IsInf(result, 0) {
+		return 0, fmt.Errorf("%s overflows float64", integer)
+	}
+	return result, nil
+}
@@ -0,0 +1,120 @@
+package prefer
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]ByteSize{
+		"512":      512,
+		"512B":     512,
+		"10KB":     10 * 1000,
+		"10kib":    10 * 1024,
+		"10MiB":    10 << 20,
+		"10 MB":    10 * 1000 * 1000,
+		"1.5GiB":   3 << 29,
+		"2T":       2 * 1000 * 1000 * 1000 * 1000,
+		"0.5KiB":   512,
+		"15EiB":    15 << 60,
+		" 64 kib ": 64 << 10,
+	}
+
+	for text, expected := range cases {
+		size, err := ParseByteSize(text)
+		if err != nil || size != expected {
+			t.Errorf("Expected %q to be %d, got %d (%v)", text, expected, size, err)
+		}
+	}
+
+	for _, text := range []string{"", "MiB", "10XB", "-1KB", "1.5B", "16EiB", "1.2.3KB"} {
+		if _, err := ParseByteSize(text); err == nil {
+			t.Errorf("Expected %q to be invalid", text)
+		}
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	cases := map[ByteSize]string{
+		0:                 "0B",
+		1500:              "1500B",
+		2048:              "2KiB",
+		10 << 20:          "10MiB",
+		10 * 1000 * 1000:  "10MB",
+		3 * (1 << 30):     "3GiB",
+		1000 * 1000 * 999: "999MB",
+	}
+
+	for size, expected := range cases {
+		if size.String() != expected {
+			t.Errorf("Expected %d to format as %s, got %s", uint64(size), expected, size.String())
+		}
+		parsed, err := ParseByteSize(size.String())
+		if err != nil || parsed != size {
+			t.Errorf("Expected %s to round trip, got %d (%v)", size.String(), parsed, err)
+		}
+	}
+}
+
+func TestDecodeStandardLibraryTypes(t *testing.T) {
+	type Config struct {
+		Timeout  time.Duration  `prefer:"timeout"`
+		MaxBody  ByteSize       `prefer:"max_body"`
+		Endpoint *url.URL       `prefer:"endpoint"`
+		Mirror   url.URL        `prefer:"mirror"`
+		Listen   net.IP         `prefer:"listen"`
+		Allowed  []*net.IPNet   `prefer:"allowed"`
+		Pattern  *regexp.Regexp `prefer:"pattern"`
+		Started  time.Time      `prefer:"started"`
+		Released time.Time      `prefer:"released"`
+	}
+
+	tree := map[string]interface{}{
+		"timeout":  "30s",
+		"max_body": "10MiB",
+		"endpoint": "https://example.com/api?x=1",
+		"mirror":   "http://mirror.example.com",
+		"listen":   "0.0.0.0",
+		"allowed":  []interface{}{"10.0.0.0/8", "192.168.1.0/24"},
+		"pattern":  "^foo",
+		"started":  "2024-05-01T12:30:00Z",
+		"released": "2024-05-01",
+	}
+
+	var config Config
+	checkTestError(t, decodeTree(tree, &config, KeyNamingDefault, false))
+
+	if config.Timeout != 30*time.Second || config.MaxBody != 10<<20 {
+		t.Error("Unexpected duration or size:", config.Timeout, config.MaxBody)
+	}
+	if config.Endpoint == nil || config.Endpoint.Host != "example.com" || config.Mirror.Host != "mirror.example.com" {
+		t.Error("Unexpected URLs:", config.Endpoint, config.Mirror)
+	}
+	if !config.Listen.Equal(net.IPv4zero) {
+		t.Error("Unexpected IP:", config.Listen)
+	}
+	if len(config.Allowed) != 2 || !config.Allowed[1].Contains(net.ParseIP("192.168.1.7")) {
+		t.Error("Unexpected networks:", config.Allowed)
+	}
+	if config.Pattern == nil || !config.Pattern.MatchString("foobar") {
+		t.Error("Unexpected pattern:", config.Pattern)
+	}
+	if config.Started.Hour() != 12 || config.Released.Year() != 2024 {
+		t.Error("Unexpected times:", config.Started, config.Released)
+	}
+
+	err := decodeTree(map[string]interface{}{
+		"pattern":  "([",
+		"allowed":  []interface{}{"10.0.0.0"},
+		"released": "yesterday",
+		"max_body": "lots",
+	}, &config, KeyNamingDefault, false)
+
+	decodeError, ok := err.(*DecodeError)
+	if !ok || len(decodeError.Errors) != 4 {
+		t.Error("Expected four failures, got:", err)
+	}
+}
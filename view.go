@@ -0,0 +1,139 @@
+package prefer
+
+import (
+	"strconv"
+)
+
+// Sub returns a view of the map at prefix, which resolves keys relative to
+// the prefix. The view is live: it sees later changes to the parent, and
+// values set through it are stored in the parent, creating the prefix if it
+// doesn't exist yet. Views can be decoded and watched like any other map.
+//
+// For example, config.Sub("database").GetString("host") reads the same
+// value as config.GetString("database.host").
+func (c *ConfigMap) Sub(prefix string) *ConfigMap {
+	if prefix == "" {
+		return c
+	}
+	segments, err := c.resolve(prefix)
+	return &ConfigMap{parent: c.root(), prefix: segments, prefixErr: err}
+}
+
+// Prefix returns the key path of a view returned by Sub, relative to the
+// root map, or an empty string for the root map itself.
+func (c *ConfigMap) Prefix() string {
+	return formatKeyPath(c.prefix)
+}
+
+// root returns the map which holds the data for c.
+func (c *ConfigMap) root() *ConfigMap {
+	if c.parent != nil {
+		return c.parent
+	}
+	return c
+}
+
+// resolve parses key and returns its path relative to the root map.
+func (c *ConfigMap) resolve(key string) ([]pathSegment, error) {
+	if c.prefixErr != nil {
+		return nil, c.prefixErr
+	}
+
+	segments := make([]pathSegment, len(c.prefix))
+	copy(segments, c.prefix)
+	if key == "" {
+		return segments, nil
+	}
+
+	parsed, err := parseKeyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return append(segments, parsed...), nil
+}
+
+type mapWatcher struct {
+	view    *ConfigMap
+	channel chan *ConfigMap
+}
+
+// Watch returns a channel which receives the map each time a value within
+// it is changed by Set, whether through this map, its parent or another view.
+// Changes made before the previous notification has been received are
+// coalesced into it. The channel is closed once done is closed, and done may
+// be nil to watch for as long as the map exists.
+func (c *ConfigMap) Watch(done <-chan struct{}) <-chan *ConfigMap {
+	root := c.root()
+	watcher := &mapWatcher{view: c, channel: make(chan *ConfigMap, 1)}
+
+	root.watchMutex.Lock()
+	root.watchers = append(root.watchers, watcher)
+	root.watchMutex.Unlock()
+
+	if done != nil {
+		go func() {
+			<-done
+			root.watchMutex.Lock()
+			defer root.watchMutex.Unlock()
+			for index, existing := range root.watchers {
+				if existing == watcher {
+					root.watchers = append(root.watchers[:index], root.watchers[index+1:]...)
+					break
+				}
+			}
+			close(watcher.channel)
+		}()
+	}
+
+	return watcher.channel
+}
+
+// notify informs watchers whose view contains, or is contained by, the value
+// which was changed at path.
+func (c *ConfigMap) notify(path []pathSegment) {
+	c.watchMutex.Lock()
+	defer c.watchMutex.Unlock()
+
+	for _, watcher := range c.watchers {
+		if watcher.view.prefixErr != nil || !pathsOverlap(watcher.view.prefix, path) {
+			continue
+		}
+		select {
+		case watcher.channel <- watcher.view:
+		default:
+		}
+	}
+}
+
+// pathsOverlap reports whether one of the paths is a prefix of the other.
+// Segments which can't be resolved without the data, such as negative
+// indexes, are assumed to match.
+func pathsOverlap(a, b []pathSegment) bool {
+	for index := 0; index < len(a) && index < len(b); index++ {
+		first, firstAny := segmentName(a[index])
+		second, secondAny := segmentName(b[index])
+		if !firstAny && !secondAny && first != second {
+			return false
+		}
+	}
+	return true
+}
+
+// segmentName returns the key or index which segment addresses, or true if
+// it depends on the length of a list.
+func segmentName(segment pathSegment) (string, bool) {
+	switch segment.kind {
+	case segmentIndex:
+		if segment.index < 0 {
+			return "", true
+		}
+		return strconv.Itoa(segment.index), false
+	case segmentAppend:
+		return "", true
+	case segmentBare:
+		if index, err := strconv.Atoi(segment.key); err == nil && index < 0 {
+			return "", true
+		}
+	}
+	return segment.key, false
+}
@@ -0,0 +1,132 @@
+package prefer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigMapSub(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{
+		"database": map[string]interface{}{
+			"host": "localhost",
+			"port": 5432,
+			"replicas": []interface{}{
+				map[string]interface{}{"host": "replica"},
+			},
+		},
+	})
+
+	database := config.Sub("database")
+	if host, _ := database.GetString("host"); host != "localhost" {
+		t.Error("Expected host to be resolved relative to the prefix")
+	}
+	if host, _ := database.Sub("replicas[0]").GetString("host"); host != "replica" {
+		t.Error("Expected nested views to resolve relative to both prefixes")
+	}
+	if database.Prefix() != "database" || database.Sub("replicas.0").Prefix() != "database.replicas.0" {
+		t.Errorf("Expected prefixes relative to the root, got %s", database.Sub("replicas.0").Prefix())
+	}
+	if len(database.Keys()) != 3 {
+		t.Errorf("Expected the view's keys, got %v", database.Keys())
+	}
+
+	checkTestError(t, config.Set("database.host", "db.internal"))
+	if host, _ := database.GetString("host"); host != "db.internal" {
+		t.Error("Expected the view to see changes to its parent")
+	}
+
+	checkTestError(t, database.Set("user", "admin"))
+	if user, _ := config.GetString("database.user"); user != "admin" {
+		t.Error("Expected values set through the view to be stored in the parent")
+	}
+}
+
+func TestConfigMapSubMissingPrefix(t *testing.T) {
+	config := NewConfigMap(nil)
+	cache := config.Sub("cache.redis")
+
+	if cache.Has("host") || len(cache.Data()) != 0 {
+		t.Error("Expected a view of a missing prefix to be empty")
+	}
+
+	checkTestError(t, cache.Set("host", "localhost"))
+	if host, _ := config.GetString("cache.redis.host"); host != "localhost" {
+		t.Error("Expected setting through the view to create its prefix")
+	}
+
+	invalid := config.Sub("cache..redis")
+	if invalid.Has("host") {
+		t.Error("Expected a view with an invalid prefix to be empty")
+	}
+	if err := invalid.Set("host", "x"); err == nil {
+		t.Error("Expected an error setting through a view with an invalid prefix")
+	}
+}
+
+func TestConfigMapSubDecode(t *testing.T) {
+	type Database struct {
+		Host string `prefer:"host"`
+		Port int    `prefer:"port"`
+	}
+
+	config := NewConfigMap(map[string]interface{}{
+		"database": map[string]interface{}{"host": "localhost", "port": "5432"},
+	})
+
+	var database Database
+	checkTestError(t, config.Sub("database").Decode(&database))
+	if database.Host != "localhost" || database.Port != 5432 {
+		t.Errorf("Expected the view to decode on its own, got %+v", database)
+	}
+}
+
+func TestConfigMapWatch(t *testing.T) {
+	config := NewConfigMap(map[string]interface{}{
+		"database": map[string]interface{}{"host": "localhost"},
+		"server":   map[string]interface{}{"port": 8080},
+	})
+
+	done := make(chan struct{})
+	database := config.Sub("database")
+	changes := database.Watch(done)
+
+	checkTestError(t, config.Set("server.port", 9090))
+	select {
+	case <-changes:
+		t.Error("Expected changes outside the view to be ignored")
+	default:
+	}
+
+	checkTestError(t, config.Set("database.host", "db.internal"))
+	select {
+	case view := <-changes:
+		if host, _ := view.GetString("host"); host != "db.internal" {
+			t.Error("Expected the notification to carry the updated view")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a notification for a change within the view")
+	}
+
+	checkTestError(t, config.Set("database", map[string]interface{}{"host": "replaced"}))
+	checkTestError(t, database.Set("port", 5432))
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a notification when the view's prefix is replaced")
+	}
+	select {
+	case <-changes:
+		t.Error("Expected unread notifications to be coalesced")
+	default:
+	}
+
+	close(done)
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Error("Expected the channel to be closed once done is closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the channel to be closed once done is closed")
+	}
+}
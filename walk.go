@@ -0,0 +1,246 @@
+package prefer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// SkipSubtree is returned by a WalkFunc to skip the children of the map or
+// list it was called with. It is not returned as an error by Walk.
+var SkipSubtree = errors.New("skip this subtree")
+
+// WalkFunc is called by Walk for every value in a configuration, including
+// maps and lists before their contents. path is the value's key path.
+type WalkFunc func(path string, value interface{}) error
+
+// Walk calls fn for every value in the map, depth first with map keys in
+// sorted order and list elements in order. Paths use dots for both map keys
+// and list indexes, as in "servers.0.host", and quote keys which contain
+// dots. If fn returns an error other than SkipSubtree, Walk stops and
+// returns it.
+func (c *ConfigMap) Walk(fn WalkFunc) error {
+	root, ok := c.Get("")
+	if !ok {
+		return nil
+	}
+	err := walkChildren("", root, fn)
+	if errors.Is(err, SkipSubtree) {
+		return nil
+	}
+	return err
+}
+
+func walkValue(path string, value interface{}, fn WalkFunc) error {
+	if err := fn(path, value); err != nil {
+		if errors.Is(err, SkipSubtree) {
+			return nil
+		}
+		return err
+	}
+	return walkChildren(path, value, fn)
+}
+
+func walkChildren(path string, value interface{}, fn WalkFunc) error {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(node))
+		for key := range node {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := walkValue(joinPath(path, key), node[key], fn); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for index, item := range node {
+			if err := walkValue(joinPath(path, strconv.Itoa(index)), item, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isLeaf reports whether value has no children to walk, which includes
+// empty maps and lists.
+func isLeaf(value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return isEmptyContainer(value)
+	}
+	return true
+}
+
+// AllKeys returns the key path of every value which isn't a map or list, in
+// the order Walk visits them. Empty maps and lists are included, since they
+// have no keys beneath them.
+func (c *ConfigMap) AllKeys() []string {
+	var keys []string
+	c.Walk(func(path string, value interface{}) error {
+		if isLeaf(value) {
+			keys = append(keys, path)
+		}
+		return nil
+	})
+	return keys
+}
+
+// Delete removes the value at key, returning whether it existed. Maps and
+// lists which are left empty are removed from their parents, though list
+// elements are never removed other than by naming them, so that the indexes
+// of the remaining elements don't change. Delete returns false, removing
+// nothing, when the map is frozen.
+func (c *ConfigMap) Delete(key string) bool {
+	if key == "" && c.parent == nil {
+		return false
+	}
+
+	segments, err := c.resolve(key)
+	if err != nil || len(segments) == 0 {
+		return false
+	}
+
+	err = c.update(segments, func(data map[string]interface{}) (map[string]interface{}, error) {
+		updated, deleted := deletePath(data, segments)
+		if !deleted {
+			return nil, ErrKeyNotFound
+		}
+		return updated.(map[string]interface{}), nil
+	})
+	return err == nil
+}
+
+// deletePath removes the value at segments below node, and returns the
+// updated node. As with setPath, node itself is left unchanged.
+func deletePath(node interface{}, segments []pathSegment) (interface{}, bool) {
+	segment, rest := segments[0], segments[1:]
+
+	switch current := node.(type) {
+	case map[string]interface{}:
+		if segment.kind == segmentIndex || segment.kind == segmentAppend {
+			return node, false
+		}
+		child, ok := current[segment.key]
+		if !ok {
+			return node, false
+		}
+		result := copyMap(current)
+		if len(rest) == 0 {
+			delete(result, segment.key)
+			return result, true
+		}
+
+		updated, deleted := deletePath(child, rest)
+		if !deleted {
+			return node, false
+		}
+		if isEmptyContainer(updated) {
+			delete(result, segment.key)
+		} else {
+			result[segment.key] = updated
+		}
+		return result, true
+	case []interface{}:
+		index, ok := segment.listIndex(len(current))
+		if !ok || index < 0 || index >= len(current) {
+			return node, false
+		}
+		if len(rest) == 0 {
+			result := make([]interface{}, 0, len(current)-1)
+			return append(append(result, current[:index]...), current[index+1:]...), true
+		}
+
+		updated, deleted := deletePath(current[index], rest)
+		if !deleted {
+			return node, false
+		}
+		result := copyList(current)
+		result[index] = updated
+		return result, true
+	}
+
+	return node, false
+}
+
+func isEmptyContainer(value interface{}) bool {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		return len(node) == 0
+	case []interface{}:
+		return len(node) == 0
+	}
+	return false
+}
+
+// Flatten returns the map's values keyed by the paths AllKeys returns.
+func (c *ConfigMap) Flatten() map[string]interface{} {
+	data, _ := c.current().(map[string]interface{})
+	return Flatten(data)
+}
+
+// Flatten converts a nested configuration into a flat map from key paths,
+// such as "servers.0.host", to the values which aren't maps or lists. Empty
+// maps and lists are kept as values so that Unflatten restores them.
+func Flatten(data map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	walkChildren("", data, func(path string, value interface{}) error {
+		if isLeaf(value) {
+			flat[path] = value
+		}
+		return nil
+	})
+	return flat
+}
+
+// Unflatten converts a flat map from key paths to values into a nested
+// configuration, as the reverse of Flatten. Maps whose keys are exactly the
+// numbers 0 to n-1 become lists, as they do for EnvSource.
+func Unflatten(flat map[string]interface{}) (map[string]interface{}, error) {
+	type entry struct {
+		key      string
+		segments []pathSegment
+	}
+	entries := make([]entry, 0, len(flat))
+	for key := range flat {
+		segments, err := parseKeyPath(key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{key: key, segments: segments})
+	}
+	// List indexes are set in numeric order, so that each extends the list
+	sort.Slice(entries, func(i, j int) bool {
+		return lessPath(entries[i].segments, entries[j].segments)
+	})
+
+	var result interface{} = make(map[string]interface{})
+	for _, e := range entries {
+		var err error
+		if result, err = setPath(result, e.segments, flat[e.key], nil); err != nil {
+			return nil, fmt.Errorf("cannot set %s: %w", e.key, err)
+		}
+	}
+	return numberedChildrenToLists(result.(map[string]interface{}), nil)
+}
+
+// lessPath orders key paths segment by segment, comparing list indexes as
+// numbers and other segments as text.
+func lessPath(a, b []pathSegment) bool {
+	for index := 0; index < len(a) && index < len(b); index++ {
+		x, y := a[index], b[index]
+		if x.kind == segmentIndex && y.kind == segmentIndex {
+			if x.index != y.index {
+				return x.index < y.index
+			}
+			continue
+		}
+		if x.String() != y.String() {
+			return x.String() < y.String()
+		}
+	}
+	return len(a) < len(b)
+}
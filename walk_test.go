@@ -0,0 +1,172 @@
+package prefer
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func newWalkTestMap() *ConfigMap {
+	return NewConfigMap(map[string]interface{}{
+		"name": "app",
+		"database": map[string]interface{}{
+			"port": 5432,
+			"host": "localhost",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"host": "a"},
+			map[string]interface{}{"host": "b"},
+		},
+		"hosts": map[string]interface{}{
+			"example.com": "10.0.0.1",
+		},
+		"empty": map[string]interface{}{},
+	})
+}
+
+func TestConfigMapAllKeys(t *testing.T) {
+	expected := []string{
+		"database.host",
+		"database.port",
+		"empty",
+		`hosts."example.com"`,
+		"name",
+		"servers.0.host",
+		"servers.1.host",
+	}
+
+	config := newWalkTestMap()
+	keys := config.AllKeys()
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+
+	for _, key := range keys {
+		if !config.Has(key) {
+			t.Errorf("Expected %s to be a valid key path", key)
+		}
+	}
+
+	if keys := config.Sub("database").AllKeys(); !reflect.DeepEqual(keys, []string{"host", "port"}) {
+		t.Errorf("Expected keys relative to the view, got %v", keys)
+	}
+}
+
+func TestConfigMapWalk(t *testing.T) {
+	var visited []string
+	err := newWalkTestMap().Walk(func(path string, value interface{}) error {
+		visited = append(visited, path)
+		if path == "servers" {
+			return SkipSubtree
+		}
+		return nil
+	})
+	checkTestError(t, err)
+
+	expected := []string{"database", "database.host", "database.port", "empty", "hosts", `hosts."example.com"`, "name", "servers"}
+	if !reflect.DeepEqual(visited, expected) {
+		t.Errorf("Expected %v, got %v", expected, visited)
+	}
+
+	stop := errors.New("stop")
+	visited = nil
+	err = newWalkTestMap().Walk(func(path string, value interface{}) error {
+		visited = append(visited, path)
+		return stop
+	})
+	if !errors.Is(err, stop) || len(visited) != 1 {
+		t.Errorf("Expected Walk to stop at the first error, got %v after %v", err, visited)
+	}
+}
+
+func TestConfigMapDelete(t *testing.T) {
+	config := newWalkTestMap()
+
+	if !config.Delete("database.host") || config.Has("database.host") {
+		t.Error("Expected database.host to be deleted")
+	}
+	if !config.Has("database.port") {
+		t.Error("Expected database.port to remain")
+	}
+
+	if !config.Delete("database.port") || config.Has("database") {
+		t.Error("Expected the empty database map to be pruned")
+	}
+
+	if !config.Delete("servers[0]") {
+		t.Error("Expected servers[0] to be deleted")
+	}
+	if host, _ := config.GetString("servers.0.host"); host != "b" {
+		t.Error("Expected later list elements to move down")
+	}
+
+	if !config.Delete("servers.0.host") {
+		t.Error("Expected servers.0.host to be deleted")
+	}
+	if servers, _ := config.GetSlice("servers"); len(servers) != 1 {
+		t.Error("Expected list elements which become empty to remain in place")
+	}
+
+	if !config.Sub("hosts").Delete(`"example.com"`) || config.Has("hosts") {
+		t.Error("Expected deleting through a view to prune the view's prefix")
+	}
+
+	for _, key := range []string{"", "missing", "name.nested", "a..b"} {
+		if config.Delete(key) {
+			t.Errorf("Expected deleting %q to fail", key)
+		}
+	}
+}
+
+func TestFlattenAndUnflatten(t *testing.T) {
+	config := newWalkTestMap()
+	flat := config.Flatten()
+
+	expected := map[string]interface{}{
+		"database.host":       "localhost",
+		"database.port":       int64(5432),
+		"empty":               map[string]interface{}{},
+		`hosts."example.com"`: "10.0.0.1",
+		"name":                "app",
+		"servers.0.host":      "a",
+		"servers.1.host":      "b",
+	}
+	if !reflect.DeepEqual(flat, expected) {
+		t.Errorf("Expected %v, got %v", expected, flat)
+	}
+
+	nested, err := Unflatten(flat)
+	checkTestError(t, err)
+	if !reflect.DeepEqual(nested, config.Data()) {
+		t.Errorf("Expected Unflatten to restore %v, got %v", config.Data(), nested)
+	}
+
+	if _, err := Unflatten(map[string]interface{}{"a": 1, "a.b": 2}); err == nil {
+		t.Error("Expected an error when a path passes through a value")
+	}
+}
+
+func TestFlattenAndUnflattenLongLists(t *testing.T) {
+	servers := make([]interface{}, 12)
+	ports := make([]interface{}, 12)
+	indexed := make(map[string]interface{}, 12)
+	for index := range servers {
+		servers[index] = map[string]interface{}{"port": int64(index)}
+		ports[index] = int64(index)
+		indexed["ports["+strconv.Itoa(index)+"]"] = int64(index)
+	}
+	config := NewConfigMap(map[string]interface{}{"servers": servers})
+
+	nested, err := Unflatten(config.Flatten())
+	checkTestError(t, err)
+	if !reflect.DeepEqual(nested, config.Data()) {
+		t.Errorf("Expected Unflatten to restore %v, got %v", config.Data(), nested)
+	}
+
+	nested, err = Unflatten(indexed)
+	checkTestError(t, err)
+	if !reflect.DeepEqual(nested["ports"], ports) {
+		t.Errorf("Expected indexes to be set in order, got %v", nested["ports"])
+	}
+}